@@ -0,0 +1,270 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	baoConfig "github.com/michel-thebeau-WR/openbao-manager-go/baomon/config"
+	clientapi "github.com/openbao/openbao/api/v2"
+	"github.com/spf13/cobra"
+)
+
+var bootstrapAuthMethod string
+var bootstrapPolicyName string
+var bootstrapTokenPeriod int
+var bootstrapK8sAuthMount string
+var bootstrapK8sAuthRole string
+var bootstrapServiceAccount string
+var bootstrapServiceAccountNamespace string
+
+// Default path of the kubernetes auth method
+var defaultK8sAuthMount string = "kubernetes"
+
+// The paths the monitor uses with its manager credential, with the
+// capabilities it needs on them. The token lookup and renewal are allowed by
+// the default policy.
+var managerCapabilities = map[string][]string{
+	"sys/storage/raft/configuration":   {"read"},
+	"sys/storage/raft/autopilot/state": {"read"},
+	"sys/storage/raft/remove-peer":     {"update"},
+	"sys/storage/raft/snapshot":        {"read", "update"},
+	"sys/storage/raft/snapshot-force":  {"update"},
+}
+
+// Get the policy of the monitor. It only allows the raft operations the
+// monitor runs, listed in managerCapabilities.
+func managerPolicy() string {
+	var policy strings.Builder
+	for _, path := range slices.Sorted(maps.Keys(managerCapabilities)) {
+		capabilities := make([]string, len(managerCapabilities[path]))
+		for i, capability := range managerCapabilities[path] {
+			capabilities[i] = strconv.Quote(capability)
+		}
+		fmt.Fprintf(&policy, "\npath %q {\n  capabilities = [%v]\n}\n", path, strings.Join(capabilities, ", "))
+	}
+	return policy.String()
+}
+
+// Create a periodic orphan token with the manager policy. The token is an
+// orphan so that it is not revoked with the root token.
+func createManagerToken(rootClient *clientapi.Client) (baoConfig.Token, error) {
+	slog.Debug(fmt.Sprintf("Creating a periodic token with policy %v", bootstrapPolicyName))
+	renewable := true
+	secret, err := rootClient.Auth().Token().CreateOrphan(&clientapi.TokenCreateRequest{
+		Policies:    []string{bootstrapPolicyName},
+		Period:      strconv.Itoa(bootstrapTokenPeriod) + "s",
+		DisplayName: "baomon-manager",
+		Renewable:   &renewable,
+	})
+	if err != nil {
+		return baoConfig.Token{}, fmt.Errorf("error during call to create token: %v", err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return baoConfig.Token{}, fmt.Errorf("empty response from create token")
+	}
+
+	return baoConfig.Token{Duration: bootstrapTokenPeriod, Key: secret.Auth.ClientToken}, nil
+}
+
+// Create the kubernetes auth role of the monitor with the manager policy
+func createManagerRole(rootClient *clientapi.Client) error {
+	mount := bootstrapK8sAuthMount
+	namespace := bootstrapServiceAccountNamespace
+	if namespace == "" {
+		namespace = globalConfig.GetNamespace()
+	}
+	slog.Debug(fmt.Sprintf("Creating the kubernetes auth role %v at auth/%v", bootstrapK8sAuthRole, mount))
+
+	_, err := rootClient.Logical().Write(fmt.Sprintf("auth/%v/role/%v", mount, bootstrapK8sAuthRole), map[string]interface{}{
+		"bound_service_account_names":      []string{bootstrapServiceAccount},
+		"bound_service_account_namespaces": []string{namespace},
+		"token_policies":                   []string{bootstrapPolicyName},
+		"token_period":                     bootstrapTokenPeriod,
+	})
+	if err != nil {
+		return fmt.Errorf("error during call to create the kubernetes auth role: %v", err)
+	}
+
+	return nil
+}
+
+// Check that the manager credential of the monitor config can authenticate,
+// and has the capabilities the monitor needs
+func verifyManagerCredential(dnshost string) error {
+	// Use the manager credential regardless of the token source
+	verifyConfig := globalConfig
+	if bootstrapAuthMethod == "kubernetes" {
+		verifyConfig.TokenSource = baoConfig.TokenSourceKubernetes
+		verifyConfig.TokenName = ""
+	} else {
+		verifyConfig.TokenSource = baoConfig.TokenSourceTokens
+		verifyConfig.TokenName = baoConfig.ManagerTokenID
+	}
+	client, err := verifyConfig.SetupAuthClient(dnshost)
+	if err != nil {
+		return err
+	}
+	lookup, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return fmt.Errorf("error during call to look up the manager token: %v", err)
+	}
+	policies, err := lookup.TokenPolicies()
+	if err != nil {
+		return fmt.Errorf("unable to read the policies of the manager token: %v", err)
+	}
+	slog.Debug(fmt.Sprintf("The manager credential has the policies %v", policies))
+
+	var missing []string
+	for _, path := range slices.Sorted(maps.Keys(managerCapabilities)) {
+		capabilities, err := client.Sys().CapabilitiesSelf(path)
+		if err != nil {
+			return fmt.Errorf("error during call to check the capabilities of the manager token: %v", err)
+		}
+		if slices.Contains(capabilities, "root") {
+			continue
+		}
+		for _, capability := range managerCapabilities[path] {
+			if !slices.Contains(capabilities, capability) {
+				missing = append(missing, fmt.Sprintf("%v on %v", capability, path))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the manager credential is missing the capabilities: %v", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Store the monitor config changes of the bootstrap command
+func storeBootstrapConfig() error {
+	if useK8sConfig {
+		k8sconfig, err := getK8sConfig()
+		if err != nil {
+			return err
+		}
+		err = globalConfig.StoreManagerSecret(k8sconfig)
+		if err != nil {
+			return err
+		}
+		if globalConfig.RootTokenRevoked {
+			return globalConfig.DeleteRootTokenSecret(k8sconfig)
+		}
+		return nil
+	}
+
+	return writeConfigFile()
+}
+
+// Create the manager policy and credential of the monitor on dnshost,
+// store the credential, and revoke the root token.
+func bootstrapServer(dnshost string) error {
+	if globalConfig.RootTokenRevoked {
+		return fmt.Errorf("the root token was already revoked")
+	}
+	rootToken, err := globalConfig.GetRootToken()
+	if err != nil {
+		return err
+	}
+	rootClient, err := globalConfig.SetupClient(dnshost)
+	if err != nil {
+		return err
+	}
+	rootClient.SetToken(rootToken)
+
+	slog.Debug(fmt.Sprintf("Writing the policy %v", bootstrapPolicyName))
+	err = rootClient.Sys().PutPolicy(bootstrapPolicyName, managerPolicy())
+	if err != nil {
+		return fmt.Errorf("error during call to write the policy: %v", err)
+	}
+
+	switch bootstrapAuthMethod {
+	case "token":
+		managerToken, err := createManagerToken(rootClient)
+		if err != nil {
+			return err
+		}
+		if globalConfig.Tokens == nil {
+			globalConfig.Tokens = make(map[string]baoConfig.Token)
+		}
+		globalConfig.Tokens[baoConfig.ManagerTokenID] = managerToken
+	case "kubernetes":
+		err = createManagerRole(rootClient)
+		if err != nil {
+			return err
+		}
+		globalConfig.K8sAuthMount = bootstrapK8sAuthMount
+		globalConfig.K8sAuthRole = bootstrapK8sAuthRole
+	default:
+		return fmt.Errorf("unknown auth method %v. Available methods: token, kubernetes", bootstrapAuthMethod)
+	}
+
+	// Make sure the monitor can still authenticate before losing the root token
+	err = verifyManagerCredential(dnshost)
+	if err != nil {
+		return fmt.Errorf("the manager credential does not work, keeping the root token: %v", err)
+	}
+	err = storeBootstrapConfig()
+	if err != nil {
+		return fmt.Errorf("unable to store the manager credential, keeping the root token: %v", err)
+	}
+	slog.Info("The manager credential was stored. Revoking the root token.")
+
+	err = rootClient.Auth().Token().RevokeSelf("")
+	if err != nil {
+		return fmt.Errorf("error during call to revoke the root token: %v", err)
+	}
+	globalConfig.RemoveRootToken()
+	if globalConfig.TokenSource != "" {
+		slog.Warn(fmt.Sprintf("TokenSource is set to %v. Make sure it selects the manager credential.",
+			globalConfig.TokenSource))
+	}
+
+	return storeBootstrapConfig()
+}
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap DNSHost",
+	Short: "Replace the root token with a manager credential",
+	Long: `Use the root token to create a policy for the monitor, and either a
+periodic token or a kubernetes auth role with that policy. Once the new
+credential is verified to log in with all the capabilities the monitor needs,
+and is stored, the root token is revoked and removed from
+the monitor configurations. With --k8s, the credential is stored in the
+<SecretPrefix>-manager secret and the root token secret is deleted.`,
+	Args:               cobra.ExactArgs(1),
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug(fmt.Sprintf("Action: bootstrap %v", args[0]))
+
+		cmd.SilenceUsage = true
+		err := bootstrapServer(args[0])
+		if err != nil {
+			return fmt.Errorf("bootstrap failed with error: %v", err)
+		}
+		slog.Info(fmt.Sprintf("Bootstrap successful for host %v. The root token was revoked.", args[0]))
+		return nil
+	},
+}
+
+func init() {
+	bootstrapCmd.Flags().StringVar(&bootstrapAuthMethod, "auth-method", "token", "The credential of the monitor: token or kubernetes")
+	bootstrapCmd.Flags().StringVar(&bootstrapPolicyName, "policy", "baomon-manager", "The name of the policy of the monitor")
+	bootstrapCmd.Flags().IntVar(&bootstrapTokenPeriod, "token-period", 86400, "The period, in seconds, of the token of the monitor")
+	bootstrapCmd.Flags().StringVar(&bootstrapK8sAuthMount, "k8s-auth-mount", defaultK8sAuthMount, "The path of the kubernetes auth method")
+	bootstrapCmd.Flags().StringVar(&bootstrapK8sAuthRole, "k8s-auth-role", "baomon", "The name of the kubernetes auth role of the monitor")
+	bootstrapCmd.Flags().StringVar(&bootstrapServiceAccount, "service-account", "baomon", "The service account of the monitor, bound to the kubernetes auth role")
+	bootstrapCmd.Flags().StringVar(&bootstrapServiceAccountNamespace, "service-account-namespace", "", "The namespace of the service account. Default is the Namespace of the config.")
+	RootCmd.AddCommand(bootstrapCmd)
+}
@@ -0,0 +1,154 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	baoConfig "github.com/michel-thebeau-WR/openbao-manager-go/baomon/config"
+	"github.com/spf13/cobra"
+)
+
+var noFileChecks bool
+var migrateOutput string
+
+// Write the migrated config to path, replacing it through a temporary file.
+// The file replaced is backed up according to the ConfigBackups of the
+// migrated config.
+func writeMigratedConfig(path string, data []byte, backups int) error {
+	if absPath(path) == absPath(configFile) {
+		// The monitor may be using the config file
+		err := lockConfigFile()
+		if err != nil {
+			return err
+		}
+		defer unlockConfigFile()
+	}
+
+	err := replaceConfigFile(path, data, backups)
+	if err != nil {
+		return fmt.Errorf("unable to write the migrated config file %v: %v", path, err)
+	}
+
+	return nil
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [File]",
+	Short: "Validate a config file",
+	Long: `Validate the config file File, or the config file given with --config.
+All the problems found are printed with their lines, including the unknown
+keys, and the command fails if there are any. The BAOMON_* environment
+variables and the --set flags are applied as when the monitor runs.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := configFile
+		if len(args) == 1 {
+			path = args[0]
+		}
+		slog.Debug(fmt.Sprintf("Action: config validate %v", path))
+
+		overrides, err := baoConfig.GetOverrides(configOverrides)
+		if err != nil {
+			return err
+		}
+		baoConfig.SetFileChecks(!noFileChecks)
+
+		configReader, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error in opening config file: %v, message: %v", path, err)
+		}
+		defer configReader.Close()
+
+		cmd.SilenceUsage = true
+		var configInstance baoConfig.MonitorConfig
+		err = configInstance.ReadYAMLMonitorConfigWithOverrides(configReader, overrides)
+		var configErrors baoConfig.ConfigErrors
+		if errors.As(err, &configErrors) {
+			for _, configError := range configErrors {
+				if configError.Line > 0 {
+					fmt.Fprintf(os.Stderr, "%v:%v: %v\n", path, configError.Line, configError.Message)
+				} else {
+					fmt.Fprintf(os.Stderr, "%v: %v\n", path, configError.Message)
+				}
+			}
+			return fmt.Errorf("the config file %v is invalid", path)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%v is valid\n", path)
+		return nil
+	},
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate [File]",
+	Short: "Convert a config file to the v2 format",
+	Long: `Convert the config file File, or the config file given with --config, from
+the deprecated v1 format to the v2 format. The settings are converted as they
+are, without filling in the defaults or decrypting the secrets. The converted
+config is printed, or written to the file given with --output, which can be
+the config file itself. The file replaced is backed up when ConfigBackups is
+set. The monitor keeps writing a config file in the format it was read in.
+
+The YAML comments of the config file, such as a license header, are dropped
+from the converted config.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := configFile
+		if len(args) == 1 {
+			path = args[0]
+		}
+		slog.Debug(fmt.Sprintf("Action: config migrate %v", path))
+
+		configReader, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error in opening config file: %v, message: %v", path, err)
+		}
+		defer configReader.Close()
+
+		cmd.SilenceUsage = true
+		var migrated bytes.Buffer
+		migratedConfig, err := baoConfig.MigrateYAMLMonitorConfig(configReader, &migrated)
+		if err != nil {
+			return fmt.Errorf("config migrate failed with error: %v", err)
+		}
+
+		if migrateOutput == "" {
+			fmt.Print(migrated.String())
+			return nil
+		}
+		err = writeMigratedConfig(migrateOutput, migrated.Bytes(), migratedConfig.ConfigBackups)
+		if err != nil {
+			return fmt.Errorf("config migrate failed with error: %v", err)
+		}
+		slog.Info(fmt.Sprintf("Config file %v migrated to %v", path, migrateOutput))
+		return nil
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the config file",
+	Long:  "Commands for checking and converting the monitor config file",
+}
+
+func init() {
+	configValidateCmd.Flags().BoolVar(&noFileChecks, "no-file-checks", false,
+		"Skip checking the files named in the config, such as the certificates")
+	configMigrateCmd.Flags().StringVarP(&migrateOutput, "output", "o", "",
+		"Write the converted config to this file instead of printing it")
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	RootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,131 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var configLockSuffix string = ".lock"
+
+// The advisory lock on the config file. Nil when the lock is not held.
+var configLock *os.File = nil
+
+// Take the advisory lock on the config file, waiting for another monitor
+// to release it. The lock is taken on a separate lock file, since the
+// config file is replaced on each write.
+func lockConfigFile() error {
+	if configLock != nil {
+		return nil
+	}
+	lockPath := configFile + configLockSuffix
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to open the config lock file %v: %v", lockPath, err)
+	}
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		slog.Info(fmt.Sprintf("Waiting for another monitor to release the config lock %v", lockPath))
+		err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		lockFile.Close()
+		return fmt.Errorf("unable to lock the config file with %v: %v", lockPath, err)
+	}
+	configLock = lockFile
+	slog.Debug(fmt.Sprintf("Took the config lock %v", lockPath))
+
+	return nil
+}
+
+// Release the advisory lock on the config file, if it is held
+func unlockConfigFile() {
+	if configLock == nil {
+		return
+	}
+	syscall.Flock(int(configLock.Fd()), syscall.LOCK_UN)
+	configLock.Close()
+	configLock = nil
+	slog.Debug("Released the config lock")
+}
+
+// Copy the config file at path to its first backup, after shifting the
+// older backups. The oldest backup beyond backups is removed.
+func backupConfigFile(path string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the config file to back up: %v", err)
+	}
+
+	backupPath := func(i int) string {
+		return fmt.Sprintf("%v.%v", path, i)
+	}
+	err = os.Remove(backupPath(backups))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove the oldest config backup: %v", err)
+	}
+	for i := backups - 1; i > 0; i-- {
+		err = os.Rename(backupPath(i), backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to rotate the config backups: %v", err)
+		}
+	}
+	err = os.WriteFile(backupPath(1), content, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to write the config backup: %v", err)
+	}
+	slog.Debug(fmt.Sprintf("Backed up the config file to %v", backupPath(1)))
+
+	return nil
+}
+
+// Remove all the backups of the config file at path, including those
+// beyond the current ConfigBackups
+func removeConfigBackups(path string) error {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("unable to list the config backups: %v", err)
+	}
+	prefix := filepath.Base(path) + "."
+	for _, entry := range entries {
+		suffix, found := strings.CutPrefix(entry.Name(), prefix)
+		if _, err := strconv.Atoi(suffix); !found || err != nil {
+			// Not a backup, such as the lock file
+			continue
+		}
+		match := filepath.Join(filepath.Dir(path), entry.Name())
+		err = os.Remove(match)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove the config backup %v: %v", match, err)
+		}
+		slog.Debug(fmt.Sprintf("Removed the config backup %v", match))
+	}
+
+	return nil
+}
+
+// Flush the directory of the file at path, so that a rename in it is durable
+func syncConfigDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,222 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	baoConfig "github.com/michel-thebeau-WR/openbao-manager-go/baomon/config"
+)
+
+// Time without file events before the changes are reported, so that a
+// file written in several steps is reloaded once
+var configWatchDelay time.Duration = 500 * time.Millisecond
+
+// Watch the config file and the certificate files for changes.
+// The directories are watched rather than the files, since the files are
+// replaced on write, and kubernetes replaces the mounted files by swapping
+// a "..data" symlink in the directory.
+type configWatcher struct {
+	watcher *fsnotify.Watcher
+	changed chan struct{}
+
+	mutex   sync.Mutex
+	dirs    map[string]bool
+	pending map[string]bool
+}
+
+// Get the absolute path of a file, for comparing with the file events
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+// Get the certificate files of the monitor config
+func certFiles(configInstance baoConfig.MonitorConfig) []string {
+	var files []string
+	for _, path := range []string{configInstance.CACert, configInstance.ClientCert, configInstance.ClientKey} {
+		if path != "" {
+			files = append(files, absPath(path))
+		}
+	}
+	return files
+}
+
+// Start watching the config file, and the certificate files of the global config
+func startConfigWatcher() (*configWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch the config file: %v", err)
+	}
+	configWatch := &configWatcher{
+		watcher: watcher,
+		changed: make(chan struct{}, 1),
+		dirs:    make(map[string]bool),
+		pending: make(map[string]bool),
+	}
+	err = configWatch.update()
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go configWatch.watch()
+
+	return configWatch, nil
+}
+
+// Watch the directories of the config file and of the certificate files of
+// the global config, and stop watching the directories no longer used.
+func (configWatch *configWatcher) update() error {
+	dirs := map[string]bool{filepath.Dir(absPath(configFile)): true}
+	for _, path := range certFiles(globalConfig) {
+		dirs[filepath.Dir(path)] = true
+	}
+
+	configWatch.mutex.Lock()
+	defer configWatch.mutex.Unlock()
+	for dir := range dirs {
+		if configWatch.dirs[dir] {
+			continue
+		}
+		slog.Debug(fmt.Sprintf("Watching directory %v for config changes", dir))
+		err := configWatch.watcher.Add(dir)
+		if err != nil {
+			return fmt.Errorf("unable to watch directory %v: %v", dir, err)
+		}
+		configWatch.dirs[dir] = true
+	}
+	for dir := range configWatch.dirs {
+		if dirs[dir] {
+			continue
+		}
+		slog.Debug(fmt.Sprintf("No longer watching directory %v", dir))
+		configWatch.watcher.Remove(dir)
+		delete(configWatch.dirs, dir)
+	}
+
+	return nil
+}
+
+// Collect the file events, and report them once no event came for
+// configWatchDelay
+func (configWatch *configWatcher) watch() {
+	timer := time.NewTimer(configWatchDelay)
+	timer.Stop()
+	for {
+		select {
+		case event, ok := <-configWatch.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			configWatch.mutex.Lock()
+			configWatch.pending[event.Name] = true
+			configWatch.mutex.Unlock()
+			timer.Reset(configWatchDelay)
+		case err, ok := <-configWatch.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error(fmt.Sprintf("error while watching the config file: %v", err))
+		case <-timer.C:
+			select {
+			case configWatch.changed <- struct{}{}:
+			default:
+				// A change is already reported
+			}
+		}
+	}
+}
+
+// Get the files that changed since the last call
+func (configWatch *configWatcher) takeChanges() map[string]bool {
+	configWatch.mutex.Lock()
+	defer configWatch.mutex.Unlock()
+	changes := configWatch.pending
+	configWatch.pending = make(map[string]bool)
+	return changes
+}
+
+// Check if a changed file is path, or the "..data" symlink of a kubernetes
+// volume in the directory of path
+func fileChanged(changes map[string]bool, path string) bool {
+	for name := range changes {
+		if name == path {
+			return true
+		}
+		if filepath.Dir(name) == filepath.Dir(path) && strings.HasPrefix(filepath.Base(name), "..") {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop watching the files
+func (configWatch *configWatcher) stop() {
+	configWatch.watcher.Close()
+}
+
+// Reload the config if the config file or the certificate files changed.
+// The writes of the monitor itself are ignored. A config that fails the
+// validation is rejected, and the current config is kept.
+func (configWatch *configWatcher) applyChanges() {
+	changes := configWatch.takeChanges()
+	configChanged := false
+	if fileChanged(changes, absPath(configFile)) {
+		content, err := os.ReadFile(configFile)
+		if err != nil {
+			slog.Error(fmt.Sprintf("unable to read the changed config file: %v", err))
+			return
+		}
+		configChanged = sha256.Sum256(content) != configFileSum
+		if !configChanged {
+			slog.Debug("The config file is unchanged")
+		}
+	}
+	certChanged := false
+	for _, path := range certFiles(globalConfig) {
+		if fileChanged(changes, path) {
+			slog.Info(fmt.Sprintf("The certificate file %v changed", path))
+			certChanged = true
+		}
+	}
+	if !configChanged && !certChanged {
+		return
+	}
+
+	oldCerts := strings.Join(certFiles(globalConfig), ",")
+	err := reloadConfig()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Config reload failed, keeping the current config: %v", err))
+		return
+	}
+	applyWaitInterval()
+	if certChanged || oldCerts != strings.Join(certFiles(globalConfig), ",") {
+		// The clients are created with the new certificates from now on.
+		// Log in again, since the kubernetes auth tokens are renewed with
+		// clients using the old certificates.
+		slog.Info("The certificates changed. Rebuilding the clients.")
+		baoConfig.StopK8sAuthRenewal()
+	}
+	err = configWatch.update()
+	if err != nil {
+		slog.Error(err.Error())
+	}
+}
@@ -56,7 +56,7 @@ var dumpConfigWriteCmd = &cobra.Command{
 			return err
 		}
 
-		writeFile, err := os.Create(args[1])
+		writeFile, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
 			return err
 		}
@@ -88,6 +88,40 @@ var dumpConfigPrintGlobal = &cobra.Command{
 	},
 }
 
+var dumpConfigEffective = &cobra.Command{
+	Use:   "effective",
+	Short: "Print the effective config with the source of each value",
+	Long: `Print the config after applying the defaults, the config file, the
+BAOMON_* environment variables and the --set flags, in this order. The source
+of each value is shown in a comment. The secrets are redacted.`,
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		document := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range globalConfig.EffectiveConfig() {
+			var valueNode yaml.Node
+			err := valueNode.Encode(field.Value)
+			if err != nil {
+				return err
+			}
+			keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: field.Name}
+			// Keep the comment on the line of the key
+			if len(valueNode.Content) == 0 {
+				valueNode.LineComment = field.Source
+			} else {
+				keyNode.LineComment = field.Source
+			}
+			document.Content = append(document.Content, keyNode, &valueNode)
+		}
+		configBytes, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(configBytes))
+		return nil
+	},
+}
+
 var dumpConfigCmd = &cobra.Command{
 	Use:   "dumpConfig",
 	Short: "Dev command for read/write YAML config files",
@@ -98,5 +132,6 @@ func init() {
 	dumpConfigCmd.AddCommand(dumpConfigReadCmd)
 	dumpConfigCmd.AddCommand(dumpConfigWriteCmd)
 	dumpConfigCmd.AddCommand(dumpConfigPrintGlobal)
+	dumpConfigCmd.AddCommand(dumpConfigEffective)
 	RootCmd.AddCommand(dumpConfigCmd)
 }
@@ -0,0 +1,195 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	clientapi "github.com/openbao/openbao/api/v2"
+	"github.com/spf13/cobra"
+)
+
+var generateRootCancel bool
+
+// Decode the token returned by generate-root, which is encoded by XOR with the OTP
+func decodeRootToken(encodedToken string, otp string) (string, error) {
+	tokenBytes, err := base64.RawStdEncoding.DecodeString(encodedToken)
+	if err != nil {
+		// Older servers pad the encoded token
+		tokenBytes, err = base64.StdEncoding.DecodeString(encodedToken)
+		if err != nil {
+			return "", fmt.Errorf("unable to decode the encoded root token: %v", err)
+		}
+	}
+	otpBytes := []byte(otp)
+	if len(tokenBytes) != len(otpBytes) {
+		return "", fmt.Errorf("the encoded root token and the OTP have different lengths")
+	}
+
+	for i := range tokenBytes {
+		tokenBytes[i] ^= otpBytes[i]
+	}
+	return string(tokenBytes), nil
+}
+
+// Provide the unseal key shards of the cluster of dnshost to generate-root,
+// until the threshold is reached.
+func provideGenerateRootShards(dnshost string, nonce string, client *clientapi.Client) (*clientapi.GenerateRootStatusResponse, error) {
+	shardNames := globalConfig.GetUnsealShards(dnshost)
+	if len(shardNames) == 0 {
+		return nil, fmt.Errorf("no unseal key shards belong to the cluster of %v", dnshost)
+	}
+
+	for _, shardName := range shardNames {
+		slog.Debug(fmt.Sprintf("Providing shard %v to generate-root", shardName))
+		updateResult, err := client.Sys().GenerateRootUpdate(globalConfig.UnsealKeyShards[shardName].Key, nonce)
+		if err != nil {
+			return nil, fmt.Errorf("error during call to generate-root update with shard %v: %v", shardName, err)
+		}
+		if updateResult.Complete {
+			return updateResult, nil
+		}
+	}
+
+	return nil, fmt.Errorf("exhausted all non-recovery keys associated with %v before the generate-root threshold", dnshost)
+}
+
+// Generate a new root token for the server on dnshost, and store it in
+// place of the old root token.
+func generateRootToken(dnshost string) error {
+	slog.Debug(fmt.Sprintf("Attempting to generate a root token on host %v", dnshost))
+	client, err := globalConfig.SetupClient(dnshost)
+	if err != nil {
+		return err
+	}
+
+	status, err := client.Sys().GenerateRootStatus()
+	if err != nil {
+		return fmt.Errorf("error during call to generate-root status: %v", err)
+	}
+	if status.Started {
+		return fmt.Errorf("a root token generation is already in progress on host %v. Use --cancel to cancel it", dnshost)
+	}
+
+	// Let the server generate the OTP
+	slog.Debug("Running /sys/generate-root/attempt")
+	initResult, err := client.Sys().GenerateRootInit("", "")
+	if err != nil {
+		return fmt.Errorf("error during call to generate-root init: %v", err)
+	}
+	if initResult.OTP == "" {
+		return fmt.Errorf("the server on host %v did not return an OTP", dnshost)
+	}
+
+	updateResult, err := provideGenerateRootShards(dnshost, initResult.Nonce, client)
+	if err != nil {
+		cancelGenerateRoot(dnshost, client)
+		return err
+	}
+
+	encodedToken := updateResult.EncodedToken
+	if encodedToken == "" {
+		encodedToken = updateResult.EncodedRootToken
+	}
+	rootToken, err := decodeRootToken(encodedToken, initResult.OTP)
+	if err != nil {
+		cancelGenerateRoot(dnshost, client)
+		// The root token can still be decoded from these
+		printGenerateRootResult(map[string]string{"encoded_token": encodedToken, "otp": initResult.OTP})
+		return fmt.Errorf("%v. The encoded token and the OTP were printed to stdout", err)
+	}
+
+	err = storeRootToken(rootToken)
+	if err != nil {
+		printGenerateRootResult(map[string]string{"root_token": rootToken})
+		return fmt.Errorf("unable to store the new root token, which was printed to stdout: %v", err)
+	}
+
+	return nil
+}
+
+// Cancel the root token generation on dnshost
+func cancelGenerateRoot(dnshost string, client *clientapi.Client) {
+	err := client.Sys().GenerateRootCancel()
+	if err != nil {
+		slog.Error(fmt.Sprintf("unable to cancel generate-root on host %v: %v", dnshost, err))
+	}
+}
+
+// Print the result of a root token generation that could not be stored, so
+// that the new root token is not lost
+func printGenerateRootResult(result map[string]string) {
+	resultPrint, err := json.MarshalIndent(result, "", "  ")
+	if err == nil {
+		fmt.Fprintln(os.Stdout, string(resultPrint))
+	}
+}
+
+// Store the new root token in place of the old one, in the kubernetes
+// secret or in the config file
+func storeRootToken(rootToken string) error {
+	err := globalConfig.SetRootToken(rootToken)
+	if err != nil {
+		return fmt.Errorf("the generated root token is invalid: %v", err)
+	}
+
+	if useK8sConfig {
+		k8sconfig, err := getK8sConfig()
+		if err != nil {
+			return err
+		}
+		return globalConfig.StoreRootTokenSecret(k8sconfig, rootToken)
+	}
+
+	// Write the config file now, so that the new token is not lost
+	return writeConfigFile()
+}
+
+var generateRootCmd = &cobra.Command{
+	Use:   "generate-root DNSHost",
+	Short: "Generate a new root token",
+	Long: `Generate a new root token for the server on DNSHost using the OTP flow
+and the unseal key shards of its cluster. The new root token replaces the
+root token in the monitor configurations. With --k8s, it replaces the root
+token in the kubernetes secret instead.`,
+	Args:               cobra.ExactArgs(1),
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug(fmt.Sprintf("Action: generate-root %v", args[0]))
+
+		cmd.SilenceUsage = true
+		if generateRootCancel {
+			client, err := globalConfig.SetupClient(args[0])
+			if err != nil {
+				return fmt.Errorf("generate-root cancel failed with error: %v", err)
+			}
+			err = client.Sys().GenerateRootCancel()
+			if err != nil {
+				return fmt.Errorf("generate-root cancel failed with error: %v", err)
+			}
+			slog.Info(fmt.Sprintf("Root token generation cancelled for host %v", args[0]))
+			return nil
+		}
+
+		err := generateRootToken(args[0])
+		if err != nil {
+			return fmt.Errorf("generate-root failed with error: %v", err)
+		}
+		slog.Info(fmt.Sprintf("Root token generated for host %v", args[0]))
+		return nil
+	},
+}
+
+func init() {
+	generateRootCmd.Flags().BoolVar(&generateRootCancel, "cancel", false, "Cancel a root token generation in progress")
+	RootCmd.AddCommand(generateRootCmd)
+}
@@ -1,17 +1,24 @@
 package baoCommands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 
 	clientapi "github.com/openbao/openbao/api/v2"
 	"github.com/spf13/cobra"
 )
 
 func checkHealth(dnshost string, client *clientapi.Client) (*clientapi.HealthResponse, error) {
+	return checkHealthWithContext(context.Background(), dnshost, client)
+}
+
+func checkHealthWithContext(ctx context.Context, dnshost string, client *clientapi.Client) (*clientapi.HealthResponse, error) {
 	slog.Debug(fmt.Sprintf("Attempting to check health on host %v", dnshost))
-	healthResult, err := client.Sys().Health()
+	healthResult, err := client.Sys().HealthWithContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error during call to check health: %v", err)
 	}
@@ -20,6 +27,30 @@ func checkHealth(dnshost string, client *clientapi.Client) (*clientapi.HealthRes
 	return healthResult, nil
 }
 
+// Find the active server among ServerAddresses.
+// The active server is initialized, unsealed and not in standby.
+func findActiveServer() (string, error) {
+	slog.Debug("Looking for the active server")
+	for _, host := range slices.Sorted(maps.Keys(globalConfig.ServerAddresses)) {
+		client, err := globalConfig.SetupClient(host)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("unable to create client for host %v: %v", host, err))
+			continue
+		}
+		healthResult, err := checkHealth(host, client)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("unable to check health for host %v: %v", host, err))
+			continue
+		}
+		if healthResult.Initialized && !healthResult.Sealed && !healthResult.Standby {
+			slog.Debug(fmt.Sprintf("Host %v is the active server", host))
+			return host, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to find an active server under ServerAddresses")
+}
+
 var healthCmd = &cobra.Command{
 	Use:                "health DNSHost",
 	Short:              "Check server health",
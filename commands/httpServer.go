@@ -0,0 +1,174 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default number of wait intervals without a completed cycle before
+// the liveness probe fails
+var defaultLivenessIntervals int = 3
+
+// Progress of the run command, reported by the liveness and readiness probes
+type runProgress struct {
+	mutex        sync.Mutex
+	started      time.Time
+	lastCycle    time.Time
+	liveness     time.Duration
+	configLoaded bool
+	k8sReady     bool
+	clientReady  bool
+}
+
+var runStatus runProgress
+
+// Get the time allowed between completed cycles before the liveness probe
+// fails. This is LivenessIntervals times the wait interval, plus the time a
+// cycle may spend waiting on a server.
+func livenessWindow() time.Duration {
+	intervals := defaultLivenessIntervals
+	if globalConfig.LivenessIntervals > 0 {
+		intervals = globalConfig.LivenessIntervals
+	}
+	var cycleTimeout time.Duration
+	for host := range globalConfig.ServerAddresses {
+		cycleTimeout = max(cycleTimeout, hostTimeout(host))
+	}
+	return time.Duration(intervals*waitInterval)*time.Second + cycleTimeout
+}
+
+// Record the start of the run loop.
+// The config and the kubernetes client were set up by setupCmd at this point.
+func (progress *runProgress) setStarted() {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.started = time.Now()
+	progress.liveness = livenessWindow()
+	progress.configLoaded = true
+	progress.k8sReady = true
+}
+
+// Record the completion of a run cycle
+func (progress *runProgress) setCycleComplete(results []hostCheckResult) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.lastCycle = time.Now()
+	// The config may have been reloaded
+	progress.liveness = livenessWindow()
+	progress.clientReady = false
+	for _, result := range results {
+		if result.Status != hostClientFailed {
+			progress.clientReady = true
+			break
+		}
+	}
+}
+
+// Record whether the server discovery from kubernetes succeeded
+func (progress *runProgress) setK8sReady(ready bool) {
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	progress.k8sReady = ready
+}
+
+// Write the result of a probe as JSON
+func writeProbe(w http.ResponseWriter, ok bool, checks map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(checks)
+}
+
+// The liveness probe fails if no run cycle completed within the allowed time
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	runStatus.mutex.Lock()
+	allowed := runStatus.liveness
+	lastProgress := runStatus.lastCycle
+	if lastProgress.IsZero() {
+		// Allow the first cycle the same time from the start of the loop
+		lastProgress = runStatus.started
+	}
+	runStatus.mutex.Unlock()
+
+	since := time.Since(lastProgress)
+	writeProbe(w, since <= allowed, map[string]interface{}{
+		"last_cycle_seconds_ago": int(since.Seconds()),
+		"allowed_seconds":        int(allowed.Seconds()),
+	})
+}
+
+// The readiness probe fails until the config is loaded, the kubernetes
+// client is set up, and at least one server client was set up.
+func handleReadiness(w http.ResponseWriter, r *http.Request) {
+	runStatus.mutex.Lock()
+	checks := map[string]interface{}{
+		"config":     runStatus.configLoaded,
+		"kubernetes": runStatus.k8sReady,
+		"client":     runStatus.clientReady,
+	}
+	ready := runStatus.configLoaded && runStatus.k8sReady && runStatus.clientReady
+	runStatus.mutex.Unlock()
+
+	writeProbe(w, ready, checks)
+}
+
+// Start the HTTP listeners for the metrics and probes of the run command.
+// The metrics and probes share the listener if their addresses are the same.
+func startHTTPServers() ([]*http.Server, error) {
+	muxes := make(map[string]*http.ServeMux)
+	getMux := func(address string) *http.ServeMux {
+		if _, ok := muxes[address]; !ok {
+			muxes[address] = http.NewServeMux()
+		}
+		return muxes[address]
+	}
+
+	if globalConfig.MetricsAddress != "" {
+		getMux(globalConfig.MetricsAddress).Handle("/metrics",
+			promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	}
+	if globalConfig.ProbeAddress != "" {
+		mux := getMux(globalConfig.ProbeAddress)
+		mux.HandleFunc("/healthz", handleLiveness)
+		mux.HandleFunc("/readyz", handleReadiness)
+	}
+
+	var servers []*http.Server
+	for address, mux := range muxes {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, server := range servers {
+				server.Close()
+			}
+			return nil, fmt.Errorf("unable to listen on %v: %v", address, err)
+		}
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			err := server.Serve(listener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error(fmt.Sprintf("HTTP server on %v stopped with error: %v", address, err))
+			}
+		}()
+		slog.Info(fmt.Sprintf("Serving HTTP on %v", listener.Addr()))
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
@@ -14,6 +14,7 @@ import (
 
 	clientapi "github.com/openbao/openbao/api/v2"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/rest"
 )
 
 var optFileStr string
@@ -36,6 +37,19 @@ func initializeServer(dnshost string, opts *clientapi.InitRequest) error {
 		return fmt.Errorf("The server on host %v is already initialized", dnshost)
 	}
 
+	// Make sure the init response can be stored before running init
+	var k8sconfig *rest.Config = nil
+	if useK8sConfig {
+		k8sconfig, err = getK8sConfig()
+		if err != nil {
+			return err
+		}
+		err = globalConfig.CheckInitSecrets(k8sconfig)
+		if err != nil {
+			return err
+		}
+	}
+
 	slog.Debug("Running /sys/init")
 	response, err := newClient.Sys().Init(opts)
 	if err != nil {
@@ -48,6 +62,14 @@ func initializeServer(dnshost string, opts *clientapi.InitRequest) error {
 		return fmt.Errorf("error during parsing init response: %v", err)
 	}
 
+	if useK8sConfig {
+		slog.Debug("Storing init response to kubernetes secrets")
+		err = globalConfig.StoreInitSecrets(k8sconfig, dnshost, response)
+		if err != nil {
+			return fmt.Errorf("error during storing init response to secrets: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -56,7 +78,7 @@ var initCmd = &cobra.Command{
 	Short: "Initialize the server",
 	Long: `Initialize the server using the monitor configurations.
 The key shards returned from the initResponse will be stored in the monitor
-configurations.`,
+configurations. With --k8s, they are stored in kubernetes secrets instead.`,
 	Args:              cobra.ExactArgs(1),
 	PersistentPreRunE: setupCmd,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -0,0 +1,202 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Default settings for leader election
+var defaultLeaseName string = "baomon-leader"
+var defaultLeaseDuration int = 15
+var leaderLockSuffix string = ".leader"
+
+// Leader election between several monitors. Only the leader acts on the
+// servers and writes the config, the followers only check the servers.
+type leaderElection struct {
+	identity string
+	leader   atomic.Bool
+
+	// Lock file used for leader election when not using kubernetes
+	lockPath string
+	lockFile *os.File
+
+	// Stop the Lease election, and wait for the Lease to be released
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// The leader election of the run command. Nil when leader election is disabled.
+var runLeader *leaderElection = nil
+
+// Check if this monitor is the leader.
+// This is always true when leader election is disabled.
+func isLeader() bool {
+	if runLeader == nil {
+		return true
+	}
+	if runLeader.lockPath != "" {
+		runLeader.tryFileLock()
+	}
+	return runLeader.leader.Load()
+}
+
+// Check if this monitor is still the leader before a step that changes the
+// servers, the secrets or the config. The leadership can be lost during a
+// cycle. Unlike isLeader, this does not try to take the leadership.
+func stillLeader() bool {
+	return runLeader == nil || runLeader.leader.Load()
+}
+
+// Get the identity of this monitor for leader election
+func leaderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "baomon"
+	}
+	return fmt.Sprintf("%v_%v", hostname, os.Getpid())
+}
+
+// Try to take the lock file, if this monitor does not already hold it
+func (election *leaderElection) tryFileLock() {
+	if election.lockFile != nil {
+		return
+	}
+	lockFile, err := os.OpenFile(election.lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		slog.Error(fmt.Sprintf("unable to open the leader lock file %v: %v", election.lockPath, err))
+		return
+	}
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		// Another monitor holds the lock
+		lockFile.Close()
+		return
+	}
+
+	// Record the holder of the lock for the operators
+	lockFile.Truncate(0)
+	lockFile.WriteAt([]byte(election.identity+"\n"), 0)
+	election.lockFile = lockFile
+	election.leader.Store(true)
+	slog.Info(fmt.Sprintf("Became the leader with lock file %v", election.lockPath))
+}
+
+// Start leader election using a lock file on the host.
+// The lock is released when the monitor exits.
+func startFileLockElection() *leaderElection {
+	lockPath := globalConfig.LeaderLockFile
+	if lockPath == "" {
+		lockPath = configFile + leaderLockSuffix
+	}
+	slog.Debug(fmt.Sprintf("Starting leader election with lock file %v", lockPath))
+
+	election := &leaderElection{identity: leaderIdentity(), lockPath: lockPath}
+	election.tryFileLock()
+	return election
+}
+
+// Start leader election using a kubernetes Lease.
+// The election runs until stopped, and the Lease is released then.
+func startLeaseElection(config *rest.Config) (*leaderElection, error) {
+	leaseName := globalConfig.LeaseName
+	if leaseName == "" {
+		leaseName = defaultLeaseName
+	}
+	leaseSeconds := defaultLeaseDuration
+	if globalConfig.LeaseDuration > 0 {
+		leaseSeconds = globalConfig.LeaseDuration
+	}
+	leaseDuration := time.Duration(leaseSeconds) * time.Second
+	namespace := globalConfig.GetNamespace()
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	election := &leaderElection{
+		identity: leaderIdentity(),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metaV1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: namespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: election.identity,
+		},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseDuration * 2 / 3,
+		RetryPeriod:     leaseDuration / 7,
+		ReleaseOnCancel: true,
+		Name:            leaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				slog.Info(fmt.Sprintf("Became the leader with Lease %v/%v", namespace, leaseName))
+				election.leader.Store(true)
+			},
+			OnStoppedLeading: func() {
+				slog.Info(fmt.Sprintf("Stopped being the leader with Lease %v/%v", namespace, leaseName))
+				election.leader.Store(false)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != election.identity {
+					slog.Info(fmt.Sprintf("The current leader is %v", identity))
+				}
+			},
+		},
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("unable to set up leader election: %v", err)
+	}
+
+	slog.Debug(fmt.Sprintf("Starting leader election with Lease %v/%v", namespace, leaseName))
+	go func() {
+		defer close(election.done)
+		// Run returns when leadership is lost, so keep running until stopped
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+
+	return election, nil
+}
+
+// Stop the leader election, and release the Lease or the lock file
+func (election *leaderElection) stop() {
+	if election.cancel != nil {
+		election.cancel()
+		<-election.done
+	}
+	if election.lockFile != nil {
+		syscall.Flock(int(election.lockFile.Fd()), syscall.LOCK_UN)
+		election.lockFile.Close()
+		election.lockFile = nil
+	}
+	election.leader.Store(false)
+}
@@ -0,0 +1,198 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Server states reported by the server_state metric
+var metricServerStates = []string{"active", "standby", "sealed", "not_initialized", "unknown"}
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	serverStateMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "baomon",
+		Name:      "server_state",
+		Help:      "State of each server from its last health check. 1 for the current state, 0 otherwise.",
+	}, []string{"host", "state"})
+
+	unsealAttemptsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "baomon",
+		Name:      "unseal_attempts_total",
+		Help:      "Number of attempts to unseal a sealed server.",
+	}, []string{"host"})
+
+	unsealFailuresMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "baomon",
+		Name:      "unseal_failures_total",
+		Help:      "Number of attempts to unseal a sealed server that failed.",
+	}, []string{"host"})
+
+	healthCheckDurationMetric = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "baomon",
+		Name:      "health_check_duration_seconds",
+		Help:      "Latency of the health check of each server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host"})
+
+	healthCheckFailuresMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "baomon",
+		Name:      "health_check_failures_total",
+		Help:      "Number of health checks that failed.",
+	}, []string{"host"})
+
+	discoveredServersMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "baomon",
+		Name:      "discovered_servers",
+		Help:      "Number of servers found by the last server discovery.",
+	})
+
+	discoveryFailuresMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "baomon",
+		Name:      "discovery_failures_total",
+		Help:      "Number of server discoveries from kubernetes that failed.",
+	})
+
+	lastSuccessfulCycleMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "baomon",
+		Name:      "last_successful_cycle_timestamp_seconds",
+		Help:      "Unix time of the last run cycle that discovered and checked all servers.",
+	})
+
+	leaderMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "baomon",
+		Name:      "leader",
+		Help:      "1 if this monitor is the leader that unseals the servers, 0 otherwise.",
+	})
+
+	tokenTTLMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "baomon",
+		Name:      "token_ttl_seconds",
+		Help:      "Remaining TTL of each token in Tokens from its last lookup.",
+	}, []string{"token"})
+
+	tokenRenewalsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "baomon",
+		Name:      "token_renewals_total",
+		Help:      "Number of renewals of each token in Tokens.",
+	}, []string{"token"})
+
+	tokenRenewalFailuresMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "baomon",
+		Name:      "token_renewal_failures_total",
+		Help:      "Number of renewals of each token in Tokens that failed.",
+	}, []string{"token"})
+
+	lastSnapshotMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "baomon",
+		Name:      "last_snapshot_timestamp_seconds",
+		Help:      "Unix time of the last scheduled raft snapshot that was saved.",
+	})
+
+	snapshotFailuresMetric = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "baomon",
+		Name:      "snapshot_failures_total",
+		Help:      "Number of scheduled raft snapshots that failed.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		serverStateMetric,
+		unsealAttemptsMetric,
+		unsealFailuresMetric,
+		healthCheckDurationMetric,
+		healthCheckFailuresMetric,
+		discoveredServersMetric,
+		discoveryFailuresMetric,
+		lastSuccessfulCycleMetric,
+		leaderMetric,
+		tokenTTLMetric,
+		tokenRenewalsMetric,
+		tokenRenewalFailuresMetric,
+		lastSnapshotMetric,
+		snapshotFailuresMetric,
+	)
+}
+
+// Get the server state reported by the server_state metric
+func metricServerState(result hostCheckResult) string {
+	switch result.Status {
+	case hostActive, hostUnsealed:
+		return "active"
+	case hostStandby:
+		return "standby"
+	case hostSealed, hostUnsealFailed:
+		return "sealed"
+	case hostNotInitialized:
+		return "not_initialized"
+	default:
+		return "unknown"
+	}
+}
+
+// Update the metrics from the results of a run cycle. The cycle succeeded
+// if the discovery succeeded and every server was checked without error.
+func recordCycleMetrics(results []hostCheckResult, discoveryErr error) {
+	succeeded := discoveryErr == nil
+	// Servers that are no longer discovered are removed from the metric
+	serverStateMetric.Reset()
+	for _, result := range results {
+		state := metricServerState(result)
+		for _, metricState := range metricServerStates {
+			value := 0.0
+			if metricState == state {
+				value = 1.0
+			}
+			serverStateMetric.WithLabelValues(result.Host, metricState).Set(value)
+		}
+
+		if result.Err != nil {
+			succeeded = false
+		}
+		switch result.Status {
+		case hostClientFailed:
+			continue
+		case hostCheckFailed:
+			healthCheckFailuresMetric.WithLabelValues(result.Host).Inc()
+		case hostUnsealed:
+			unsealAttemptsMetric.WithLabelValues(result.Host).Inc()
+		case hostUnsealFailed:
+			unsealAttemptsMetric.WithLabelValues(result.Host).Inc()
+			unsealFailuresMetric.WithLabelValues(result.Host).Inc()
+		}
+		healthCheckDurationMetric.WithLabelValues(result.Host).Observe(result.HealthDuration.Seconds())
+	}
+	if succeeded {
+		lastSuccessfulCycleMetric.Set(float64(time.Now().Unix()))
+	}
+}
+
+// Update the metrics from the result of a server discovery
+func recordDiscoveryMetrics(servers int, err error) {
+	if err != nil {
+		discoveryFailuresMetric.Inc()
+		return
+	}
+	discoveredServersMetric.Set(float64(servers))
+}
+
+// Update the metrics from the result of the leader election
+func recordLeaderMetrics(leader bool) {
+	if leader {
+		leaderMetric.Set(1)
+	} else {
+		leaderMetric.Set(0)
+	}
+}
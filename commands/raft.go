@@ -0,0 +1,495 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	clientapi "github.com/openbao/openbao/api/v2"
+	"github.com/spf13/cobra"
+)
+
+var leaderHost string
+var joinTimeout int
+var removeDryRun bool
+
+// A server entry from /sys/storage/raft/configuration
+type raftServer struct {
+	NodeID          string `json:"node_id" yaml:"node_id"`
+	Address         string `json:"address" yaml:"address"`
+	Leader          bool   `json:"leader" yaml:"leader"`
+	Voter           bool   `json:"voter" yaml:"voter"`
+	ProtocolVersion string `json:"protocol_version" yaml:"protocol_version"`
+}
+
+type raftConfiguration struct {
+	Servers []raftServer `json:"servers"`
+	Index   uint64       `json:"index"`
+}
+
+// Get the current raft configuration. The client must be authenticated.
+func getRaftConfiguration(client *clientapi.Client) (*raftConfiguration, error) {
+	slog.Debug("Reading /sys/storage/raft/configuration")
+	secret, err := client.Logical().Read("sys/storage/raft/configuration")
+	if err != nil {
+		return nil, fmt.Errorf("error during call to read raft configuration: %v", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("empty response from raft configuration")
+	}
+
+	configData, err := json.Marshal(secret.Data["config"])
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse raft configuration: %v", err)
+	}
+	var raftConfig raftConfiguration
+	err = json.Unmarshal(configData, &raftConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse raft configuration: %v", err)
+	}
+
+	return &raftConfig, nil
+}
+
+// Check if a raft server entry refers to the server on dnshost.
+// The node id is usually the host name of the server, and the raft address
+// uses the same host as the api address with the cluster port.
+func raftServerMatchesHost(server raftServer, dnshost string) bool {
+	if server.NodeID == dnshost {
+		return true
+	}
+	raftHost, _, err := net.SplitHostPort(server.Address)
+	if err != nil {
+		raftHost = server.Address
+	}
+	if raftHost == dnshost || strings.HasPrefix(raftHost, dnshost+".") {
+		return true
+	}
+	if addr, ok := globalConfig.ServerAddresses[dnshost]; ok {
+		return raftHost == addr.Host
+	}
+
+	return false
+}
+
+// Read the PEM files from the monitor config for the raft join request
+func readLeaderTLS(opts *clientapi.RaftJoinRequest) error {
+	readPEM := func(path string) (string, error) {
+		if path == "" {
+			return "", nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read %v: %v", path, err)
+		}
+		return string(data), nil
+	}
+
+	var err error
+	opts.LeaderCACert, err = readPEM(globalConfig.CACert)
+	if err != nil {
+		return err
+	}
+	opts.LeaderClientCert, err = readPEM(globalConfig.ClientCert)
+	if err != nil {
+		return err
+	}
+	opts.LeaderClientKey, err = readPEM(globalConfig.ClientKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Wait until the server on dnshost is listed as a voter in the raft
+// configuration of the leader.
+func waitForVoter(dnshost string, knownNodes map[string]bool, leaderClient *clientapi.Client, timeout time.Duration) (*raftServer, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		raftConfig, err := getRaftConfiguration(leaderClient)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("unable to read raft configuration from the leader: %v", err))
+		} else {
+			for _, server := range raftConfig.Servers {
+				// The new node is the entry matching the host, or the entry
+				// that was not part of the raft before the join.
+				if !raftServerMatchesHost(server, dnshost) && knownNodes[server.NodeID] {
+					continue
+				}
+				if server.Voter {
+					return &server, nil
+				}
+				slog.Debug(fmt.Sprintf("Node %v is listed in the raft but is not a voter yet", server.NodeID))
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %v to become a raft voter", dnshost)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// Join the server on dnshost to the raft cluster led by leader
+func joinRaft(dnshost string, leader string) error {
+	slog.Debug(fmt.Sprintf("Attempting to join host %v to the raft led by %v", dnshost, leader))
+	leaderClient, err := globalConfig.SetupAuthClient(leader)
+	if err != nil {
+		return err
+	}
+	leaderAddr, err := globalConfig.GetAPIAddress(leader)
+	if err != nil {
+		return err
+	}
+
+	newClient, err := globalConfig.SetupClient(dnshost)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Checking current server status")
+	healthResult, err := checkHealth(dnshost, newClient)
+	if err != nil {
+		return err
+	}
+	if healthResult.Initialized && !healthResult.Sealed {
+		return fmt.Errorf("the server on host %v is already initialized and unsealed", dnshost)
+	}
+
+	// Record the current raft members, so that the new node can be found
+	raftConfig, err := getRaftConfiguration(leaderClient)
+	if err != nil {
+		return err
+	}
+	knownNodes := make(map[string]bool, len(raftConfig.Servers))
+	for _, server := range raftConfig.Servers {
+		if raftServerMatchesHost(server, dnshost) {
+			return fmt.Errorf("the server on host %v is already a raft member as %v", dnshost, server.NodeID)
+		}
+		knownNodes[server.NodeID] = true
+	}
+
+	opts := clientapi.RaftJoinRequest{
+		LeaderAPIAddr: leaderAddr,
+	}
+	err = readLeaderTLS(&opts)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug(fmt.Sprintf("Running /sys/storage/raft/join with leader address %v", leaderAddr))
+	joinResult, err := newClient.Sys().RaftJoin(&opts)
+	if err != nil {
+		return fmt.Errorf("error during call to raft join: %v", err)
+	}
+	if !joinResult.Joined {
+		return fmt.Errorf("the server on host %v did not join the raft", dnshost)
+	}
+	slog.Info(fmt.Sprintf("Host %v joined the raft. Unsealing...", dnshost))
+
+	// The joining node completes the join once it is unsealed
+	healthResult, err = checkHealth(dnshost, newClient)
+	if err != nil {
+		return err
+	}
+	if healthResult.Sealed {
+		_, err = runUnseal(dnshost, newClient)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Debug(fmt.Sprintf("Waiting for host %v to become a raft voter", dnshost))
+	server, err := waitForVoter(dnshost, knownNodes, leaderClient, time.Duration(joinTimeout)*time.Second)
+	if err != nil {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Host %v is a raft voter with node id %v", dnshost, server.NodeID))
+
+	return nil
+}
+
+// Get the health of the raft peers reported by autopilot, by node id.
+// The client must be authenticated on the leader.
+func getRaftPeerHealth(client *clientapi.Client) (map[string]bool, error) {
+	autopilotState, err := client.Sys().RaftAutopilotState()
+	if err != nil {
+		return nil, fmt.Errorf("error during call to raft autopilot state: %v", err)
+	}
+	if autopilotState == nil {
+		return nil, fmt.Errorf("the raft autopilot state is not available")
+	}
+	healthy := make(map[string]bool, len(autopilotState.Servers))
+	for nodeID, server := range autopilotState.Servers {
+		healthy[nodeID] = server != nil && server.Healthy
+	}
+
+	return healthy, nil
+}
+
+// Check that removing the listed nodes keeps enough voters for a quorum
+// of the current raft configuration. Only the voters that are healthy count
+// towards the voters left, since a dead voter does not help the quorum.
+func checkRaftQuorum(raftConfig *raftConfiguration, healthy map[string]bool, removeNodes []string) error {
+	voters := 0
+	healthyVoters := 0
+	for _, server := range raftConfig.Servers {
+		if !server.Voter {
+			continue
+		}
+		voters++
+		if slices.Contains(removeNodes, server.NodeID) {
+			if server.Leader {
+				return fmt.Errorf("refusing to remove the raft leader %v", server.NodeID)
+			}
+			continue
+		}
+		if server.Leader || healthy[server.NodeID] {
+			healthyVoters++
+		}
+	}
+
+	quorum := voters/2 + 1
+	if healthyVoters < quorum {
+		return fmt.Errorf(
+			"removing %v would leave %v healthy of %v voters, which is below the quorum of %v",
+			removeNodes, healthyVoters, voters, quorum)
+	}
+
+	return nil
+}
+
+// Remove a peer from the raft. The client must be authenticated on the leader.
+func removeRaftPeer(client *clientapi.Client, nodeID string, dryRun bool) error {
+	raftConfig, err := getRaftConfiguration(client)
+	if err != nil {
+		return err
+	}
+	found := slices.ContainsFunc(raftConfig.Servers, func(server raftServer) bool {
+		return server.NodeID == nodeID
+	})
+	if !found {
+		return fmt.Errorf("node %v is not a raft peer", nodeID)
+	}
+	healthy, err := getRaftPeerHealth(client)
+	if err != nil {
+		return err
+	}
+	err = checkRaftQuorum(raftConfig, healthy, []string{nodeID})
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		slog.Info(fmt.Sprintf("Dry run: raft peer %v would be removed", nodeID))
+		return nil
+	}
+
+	slog.Debug(fmt.Sprintf("Running /sys/storage/raft/remove-peer for node %v", nodeID))
+	_, err = client.Logical().Write("sys/storage/raft/remove-peer", map[string]interface{}{
+		"server_id": nodeID,
+	})
+	if err != nil {
+		return fmt.Errorf("error during call to raft remove-peer: %v", err)
+	}
+	slog.Info(fmt.Sprintf("Removed raft peer %v", nodeID))
+
+	return nil
+}
+
+// The time each raft peer was first found missing from ServerAddresses
+var missingRaftPeers = make(map[string]time.Time)
+
+// The missing raft peers kept because autopilot reports them healthy, so
+// that they are reported once
+var healthyMissingRaftPeers = make(map[string]bool)
+
+// Remove the raft peers that have been missing from ServerAddresses for
+// longer than the grace period, and that autopilot reports as unhealthy.
+// A healthy peer missing from ServerAddresses is kept, since the discovery
+// of the servers can be wrong.
+func pruneRaftPeers(gracePeriod time.Duration, dryRun bool) error {
+	slog.Debug("Checking for dead raft peers")
+	leader, err := findActiveServer()
+	if err != nil {
+		return err
+	}
+	leaderClient, err := globalConfig.SetupAuthClient(leader)
+	if err != nil {
+		return err
+	}
+	raftConfig, err := getRaftConfiguration(leaderClient)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	peers := make(map[string]bool, len(raftConfig.Servers))
+	var missingPeers []string
+	for _, server := range raftConfig.Servers {
+		peers[server.NodeID] = true
+		found := false
+		for host := range globalConfig.ServerAddresses {
+			if raftServerMatchesHost(server, host) {
+				found = true
+				break
+			}
+		}
+		if found {
+			delete(missingRaftPeers, server.NodeID)
+			delete(healthyMissingRaftPeers, server.NodeID)
+			continue
+		}
+
+		missingSince, ok := missingRaftPeers[server.NodeID]
+		if !ok {
+			slog.Info(fmt.Sprintf("Raft peer %v is not listed under ServerAddresses", server.NodeID))
+			missingRaftPeers[server.NodeID] = now
+			continue
+		}
+		if now.Sub(missingSince) >= gracePeriod {
+			missingPeers = append(missingPeers, server.NodeID)
+		}
+	}
+
+	// Forget peers that are no longer part of the raft
+	for nodeID := range missingRaftPeers {
+		if !peers[nodeID] {
+			delete(missingRaftPeers, nodeID)
+			delete(healthyMissingRaftPeers, nodeID)
+		}
+	}
+
+	if len(missingPeers) == 0 {
+		slog.Debug("No dead raft peers found")
+		return nil
+	}
+
+	healthy, err := getRaftPeerHealth(leaderClient)
+	if err != nil {
+		return fmt.Errorf("not removing dead raft peers: %v", err)
+	}
+	var deadPeers []string
+	for _, nodeID := range missingPeers {
+		if !healthy[nodeID] {
+			delete(healthyMissingRaftPeers, nodeID)
+			deadPeers = append(deadPeers, nodeID)
+			continue
+		}
+		if !healthyMissingRaftPeers[nodeID] {
+			slog.Warn(fmt.Sprintf("Raft peer %v has been missing from ServerAddresses for more than %v, "+
+				"but autopilot reports it healthy. Not removing it.", nodeID, gracePeriod))
+			healthyMissingRaftPeers[nodeID] = true
+		}
+	}
+	if len(deadPeers) == 0 {
+		slog.Debug("No dead raft peers found")
+		return nil
+	}
+
+	err = checkRaftQuorum(raftConfig, healthy, deadPeers)
+	if err != nil {
+		return fmt.Errorf("not removing dead raft peers: %v", err)
+	}
+	for _, nodeID := range deadPeers {
+		if !stillLeader() {
+			return fmt.Errorf("lost the leadership, not removing raft peer %v", nodeID)
+		}
+		slog.Warn(fmt.Sprintf("Raft peer %v has been missing for more than %v and is unhealthy", nodeID, gracePeriod))
+		err = removeRaftPeer(leaderClient, nodeID, dryRun)
+		if err != nil {
+			return err
+		}
+		if !dryRun {
+			delete(missingRaftPeers, nodeID)
+		}
+	}
+
+	return nil
+}
+
+var raftJoinCmd = &cobra.Command{
+	Use:   "join DNSHost --leader DNSHost",
+	Short: "Join a server to the raft",
+	Long: `Join the server hosted on DNSHost to the raft cluster of the leader.
+The server is unsealed after the join, and the command waits until the
+server is listed as a voter.`,
+	Args:               cobra.ExactArgs(1),
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug(fmt.Sprintf("Action: raft join %v --leader %v", args[0], leaderHost))
+
+		cmd.SilenceUsage = true
+		err := joinRaft(args[0], leaderHost)
+		if err != nil {
+			return fmt.Errorf("raft join failed with error: %v", err)
+		}
+		slog.Info(fmt.Sprintf("Raft join successful for host %v", args[0]))
+
+		return nil
+	},
+}
+
+var raftRemovePeerCmd = &cobra.Command{
+	Use:   "remove-peer NodeID",
+	Short: "Remove a peer from the raft",
+	Long: `Remove the peer with NodeID from the raft. The request is sent to the
+leader, or to the active server if no leader is given. The peer is not
+removed if the remaining healthy voters would be below the quorum.`,
+	Args:               cobra.ExactArgs(1),
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug(fmt.Sprintf("Action: raft remove-peer %v", args[0]))
+
+		cmd.SilenceUsage = true
+		leader := leaderHost
+		if leader == "" {
+			var err error
+			leader, err = findActiveServer()
+			if err != nil {
+				return fmt.Errorf("raft remove-peer failed with error: %v", err)
+			}
+		}
+		leaderClient, err := globalConfig.SetupAuthClient(leader)
+		if err != nil {
+			return fmt.Errorf("raft remove-peer failed with error: %v", err)
+		}
+		err = removeRaftPeer(leaderClient, args[0], removeDryRun)
+		if err != nil {
+			return fmt.Errorf("raft remove-peer failed with error: %v", err)
+		}
+
+		return nil
+	},
+}
+
+var raftCmd = &cobra.Command{
+	Use:   "raft",
+	Short: "Manage the raft of the servers",
+	Long:  "Add or remove servers from the raft storage backend",
+}
+
+func init() {
+	raftJoinCmd.Flags().StringVar(&leaderHost, "leader", "", "DNS host of the current raft leader")
+	raftJoinCmd.MarkFlagRequired("leader")
+	raftJoinCmd.Flags().IntVar(&joinTimeout, "timeout", 120, "time in seconds to wait for the server to become a voter")
+	raftCmd.AddCommand(raftJoinCmd)
+	raftRemovePeerCmd.Flags().StringVar(&leaderHost, "leader", "", "DNS host of the current raft leader")
+	raftRemovePeerCmd.Flags().BoolVar(&removeDryRun, "dry-run", false, "only log the peer that would be removed")
+	raftCmd.AddCommand(raftRemovePeerCmd)
+	RootCmd.AddCommand(raftCmd)
+}
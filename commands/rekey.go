@@ -0,0 +1,223 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	clientapi "github.com/openbao/openbao/api/v2"
+	"github.com/spf13/cobra"
+)
+
+var rekeyShares int
+var rekeyThreshold int
+var rekeyCancel bool
+
+// Provide the current unseal key shards of the cluster of dnshost to the
+// rekey, until the threshold is reached.
+func provideRekeyShards(dnshost string, nonce string, client *clientapi.Client) (*clientapi.RekeyUpdateResponse, error) {
+	shardNames := globalConfig.GetUnsealShards(dnshost)
+	if len(shardNames) == 0 {
+		return nil, fmt.Errorf("no unseal key shards belong to the cluster of %v", dnshost)
+	}
+
+	for _, shardName := range shardNames {
+		slog.Debug(fmt.Sprintf("Providing shard %v to the rekey", shardName))
+		updateResult, err := client.Sys().RekeyUpdate(globalConfig.UnsealKeyShards[shardName].Key, nonce)
+		if err != nil {
+			return nil, fmt.Errorf("error during call to rekey update with shard %v: %v", shardName, err)
+		}
+		if updateResult.Complete {
+			return updateResult, nil
+		}
+	}
+
+	return nil, fmt.Errorf("exhausted all non-recovery keys associated with %v before the rekey threshold", dnshost)
+}
+
+// Verify the new key shards by providing them to the rekey verification,
+// until the new threshold is reached. The old key shards remain valid
+// until the verification is complete.
+func verifyRekeyShards(keys []string, nonce string, client *clientapi.Client) error {
+	for i, key := range keys {
+		slog.Debug(fmt.Sprintf("Providing new shard %v to the rekey verification", i))
+		verifyResult, err := client.Sys().RekeyVerificationUpdate(key, nonce)
+		if err != nil {
+			return fmt.Errorf("error during call to rekey verification with new shard %v: %v", i, err)
+		}
+		if verifyResult.Complete {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("the rekey verification did not complete with all new key shards")
+}
+
+// Store the new key shards in the kubernetes secrets, or in the config file
+func storeRekeyShards(dnshost string, updateResult *clientapi.RekeyUpdateResponse) error {
+	err := globalConfig.ReplaceUnsealShards(dnshost, updateResult.Keys, updateResult.KeysB64)
+	if err != nil {
+		return err
+	}
+
+	if useK8sConfig {
+		k8sconfig, err := getK8sConfig()
+		if err != nil {
+			return err
+		}
+		return globalConfig.StoreRekeySecrets(k8sconfig, dnshost, updateResult.Keys, updateResult.KeysB64)
+	}
+
+	// Write the config file now instead of on cleanup, so that the new
+	// key shards are stored as soon as they replace the old ones
+	return writeConfigFile()
+}
+
+// Replace the unseal key of the server on dnshost, and store the new key
+// shards in place of the old ones.
+func rekeyServer(dnshost string) error {
+	slog.Debug(fmt.Sprintf("Attempting to rekey the server %v", dnshost))
+	client, err := globalConfig.SetupClient(dnshost)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Checking current server status")
+	sealStatus, err := client.Sys().SealStatus()
+	if err != nil {
+		return fmt.Errorf("error during call to seal status: %v", err)
+	}
+	if !sealStatus.Initialized || sealStatus.Sealed {
+		return fmt.Errorf("the server on host %v must be initialized and unsealed to rekey", dnshost)
+	}
+
+	rekeyStatus, err := client.Sys().RekeyStatus()
+	if err != nil {
+		return fmt.Errorf("error during call to rekey status: %v", err)
+	}
+	if rekeyStatus.Started {
+		return fmt.Errorf("a rekey is already in progress on host %v. Use --cancel to cancel it", dnshost)
+	}
+
+	// Keep the current number of shares and threshold unless changed
+	opts := clientapi.RekeyInitRequest{
+		SecretShares:        sealStatus.N,
+		SecretThreshold:     sealStatus.T,
+		RequireVerification: true,
+	}
+	if rekeyShares != 0 {
+		opts.SecretShares = rekeyShares
+	}
+	if rekeyThreshold != 0 {
+		opts.SecretThreshold = rekeyThreshold
+	}
+	if opts.SecretShares < opts.SecretThreshold {
+		return fmt.Errorf("the secret-threshold %v cannot be greater than secret-shares %v",
+			opts.SecretThreshold, opts.SecretShares)
+	}
+
+	slog.Debug(fmt.Sprintf("Running /sys/rekey/init with %v shares and threshold %v",
+		opts.SecretShares, opts.SecretThreshold))
+	initResult, err := client.Sys().RekeyInit(&opts)
+	if err != nil {
+		return fmt.Errorf("error during call to rekey init: %v", err)
+	}
+
+	// Cancel the rekey if it fails before the verification is complete.
+	// The old key shards are still valid then.
+	verified := false
+	defer func() {
+		if verified {
+			return
+		}
+		slog.Warn(fmt.Sprintf("Cancelling the rekey on host %v. The old key shards are still valid.", dnshost))
+		err := client.Sys().RekeyCancel()
+		if err != nil {
+			slog.Error(fmt.Sprintf("unable to cancel the rekey on host %v: %v", dnshost, err))
+		}
+	}()
+
+	updateResult, err := provideRekeyShards(dnshost, initResult.Nonce, client)
+	if err != nil {
+		return err
+	}
+	if updateResult.VerificationRequired {
+		slog.Debug("Verifying the new key shards")
+		err = verifyRekeyShards(updateResult.Keys, updateResult.VerificationNonce, client)
+		if err != nil {
+			return err
+		}
+		slog.Info(fmt.Sprintf("The new key shards were verified on host %v. Storing them.", dnshost))
+	} else {
+		// The rekey is already complete, and the old key shards are no
+		// longer valid
+		slog.Warn(fmt.Sprintf("The server on host %v did not require verification of the new key shards. Storing them.", dnshost))
+	}
+	verified = true
+
+	err = storeRekeyShards(dnshost, updateResult)
+	if err != nil {
+		// The old key shards are no longer valid. Print the new ones so that
+		// they are not lost.
+		keysPrint, jsonErr := json.MarshalIndent(updateResult, "", "  ")
+		if jsonErr == nil {
+			fmt.Fprintln(os.Stdout, string(keysPrint))
+		}
+		return fmt.Errorf("unable to store the new key shards, which were printed to stdout: %v", err)
+	}
+
+	return nil
+}
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey DNSHost",
+	Short: "Replace the unseal key shards",
+	Long: `Generate a new unseal key for the server on DNSHost using the current
+key shards of its cluster, optionally changing the number of shares and the
+threshold. The new key shards are verified before they replace the old ones
+in the monitor configurations. With --k8s, they replace the key shards in the
+kubernetes secrets instead. The new key shards are first stored together in
+the secret <SecretPrefix>-rekey, which is read in place of the secrets of the
+key shards until they are all replaced.`,
+	Args:               cobra.ExactArgs(1),
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug(fmt.Sprintf("Action: rekey %v", args[0]))
+
+		cmd.SilenceUsage = true
+		if rekeyCancel {
+			client, err := globalConfig.SetupClient(args[0])
+			if err != nil {
+				return fmt.Errorf("rekey cancel failed with error: %v", err)
+			}
+			err = client.Sys().RekeyCancel()
+			if err != nil {
+				return fmt.Errorf("rekey cancel failed with error: %v", err)
+			}
+			slog.Info(fmt.Sprintf("Rekey cancelled for host %v", args[0]))
+			return nil
+		}
+
+		err := rekeyServer(args[0])
+		if err != nil {
+			return fmt.Errorf("rekey failed with error: %v", err)
+		}
+		slog.Info(fmt.Sprintf("Rekey successful for host %v", args[0]))
+		return nil
+	},
+}
+
+func init() {
+	rekeyCmd.Flags().IntVar(&rekeyShares, "secret-shares", 0, "The number of shares of the new key. Default is the current number.")
+	rekeyCmd.Flags().IntVar(&rekeyThreshold, "secret-threshold", 0, "The number of shares required to reconstruct the new key. Default is the current threshold.")
+	rekeyCmd.Flags().BoolVar(&rekeyCancel, "cancel", false, "Cancel a rekey in progress")
+	RootCmd.AddCommand(rekeyCmd)
+}
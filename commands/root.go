@@ -7,9 +7,12 @@
 package baoCommands
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 
 	baoConfig "github.com/michel-thebeau-WR/openbao-manager-go/baomon/config"
 	"github.com/spf13/cobra"
@@ -21,9 +24,18 @@ var configFile string
 var globalConfig baoConfig.MonitorConfig
 var logWriter *os.File
 var baoLogger *slog.Logger = nil
+var baoLogLevel = new(slog.LevelVar)
 var useK8sConfig bool
 var useInClusterConfig bool
 var kubeConfigPath string
+var configOverrides []string
+
+// The checksum of the config file content last read or written by the monitor
+var configFileSum [sha256.Size]byte
+
+// Set by the commands that write their changes to the config file as they
+// make them, so that the config is not written again on exit
+var configWrittenOnUpdate bool
 
 func getK8sConfig() (*rest.Config, error) {
 	var config *rest.Config
@@ -46,26 +58,234 @@ func getK8sConfig() (*rest.Config, error) {
 	return config, nil
 }
 
-func setupCmd(cmd *cobra.Command, args []string) error {
-	// Open config from file
-	configReader, err := os.Open(configFile)
+// Read the monitor config from the config file, and apply the BAOMON_*
+// environment variables and the --set flags over it
+func readConfigFile(configInstance *baoConfig.MonitorConfig) error {
+	overrides, err := baoConfig.GetOverrides(configOverrides)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(configFile)
 	if err != nil {
 		return fmt.Errorf("error in opening config file: %v, message: %v", configFile, err)
 	}
-	defer configReader.Close()
-	err = globalConfig.ReadYAMLMonitorConfig(configReader)
+	configFileSum = sha256.Sum256(content)
+	err = configInstance.ReadYAMLMonitorConfigWithOverrides(bytes.NewReader(content), overrides)
 	if err != nil {
 		return fmt.Errorf("error in parsing config file: %v, message: %v", configFile, err)
 	}
 
-	// Set default configuration for logs if no custum configs are given
-	logFile := globalConfig.LogPath
-	logLevel := globalConfig.LogLevel
+	return nil
+}
+
+// Write the global config to the config file
+func writeConfigFile() error {
+	return writeMonitorConfig(globalConfig)
+}
+
+// Write configInstance to the config file.
+// The config file is left as it is when its settings and secrets would not
+// change. The previous config file is kept as a backup if ConfigBackups is
+// set, except when the config file held the Tokens and UnsealKeyShards in
+// plaintext and the config is written encrypted: the plaintext backups are
+// then removed.
+func writeMonitorConfig(configInstance baoConfig.MonitorConfig) error {
+	// The run command does not hold the config lock between writes
+	if configLock == nil {
+		err := lockConfigFile()
+		if err != nil {
+			return err
+		}
+		defer unlockConfigFile()
+	}
+
+	backups := configInstance.ConfigBackups
+	current, err := os.ReadFile(configFile)
+	if err == nil {
+		unchanged, encrypting := configInstance.CompareConfigFile(current)
+		if unchanged {
+			slog.Debug("The config is unchanged. Skipping writing the config file.")
+			configFileSum = sha256.Sum256(current)
+			return nil
+		}
+		if encrypting {
+			slog.Info("Writing the config file encrypted. Removing the plaintext backups.")
+			err = removeConfigBackups(configFile)
+			if err != nil {
+				return err
+			}
+			backups = 0
+		}
+	}
+
+	var data bytes.Buffer
+	err = configInstance.WriteYAMLMonitorConfig(&data)
+	if err != nil {
+		return fmt.Errorf("error with writing the changed configs: %v", err)
+	}
+	err = replaceConfigFile(configFile, data.Bytes(), backups)
+	if err != nil {
+		return err
+	}
+	configFileSum = sha256.Sum256(data.Bytes())
+
+	return nil
+}
+
+// Replace the file at path with data.
+// The data is written and flushed to a temporary file that then replaces
+// the file, so that the file is never left partially written. The previous
+// file is kept as a backup if backups is more than 0. The config files hold
+// secrets, so they are only readable by their owner.
+func replaceConfigFile(path string, data []byte, backups int) error {
+	configWriter, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error with opening config file to write in the changed configs: %v", err)
+	}
+	defer os.Remove(configWriter.Name())
+
+	_, err = configWriter.Write(data)
+	if err != nil {
+		configWriter.Close()
+		return fmt.Errorf("error with writing the changed configs: %v", err)
+	}
+	err = configWriter.Chmod(0600)
+	if err != nil {
+		configWriter.Close()
+		return fmt.Errorf("error with writing the changed configs: %v", err)
+	}
+	err = configWriter.Sync()
+	if err != nil {
+		configWriter.Close()
+		return fmt.Errorf("error with flushing the changed configs: %v", err)
+	}
+	err = configWriter.Close()
+	if err != nil {
+		return fmt.Errorf("error with closing the changed config file: %v", err)
+	}
+	err = backupConfigFile(path, backups)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(configWriter.Name(), path)
+	if err != nil {
+		return fmt.Errorf("error with replacing the config file: %v", err)
+	}
+	err = syncConfigDir(path)
+	if err != nil {
+		return fmt.Errorf("error with flushing the config directory: %v", err)
+	}
+
+	return nil
+}
+
+// Change the config file with update, under the config lock.
+// The config file is read again first, and only the changes of update are
+// written, so that the changes made by other commands since the monitor
+// read the config file are kept.
+func updateConfigFile(update func(configInstance *baoConfig.MonitorConfig)) error {
+	if configLock == nil {
+		err := lockConfigFile()
+		if err != nil {
+			return err
+		}
+		defer unlockConfigFile()
+	}
+
+	readSum := configFileSum
+	var fileConfig baoConfig.MonitorConfig
+	err := readConfigFile(&fileConfig)
+	if err != nil {
+		return err
+	}
+	// The config file was changed by another command: let the config
+	// watcher reload it after the write
+	otherChanges := configFileSum != readSum
+
+	update(&fileConfig)
+	err = writeMonitorConfig(fileConfig)
+	if err != nil {
+		return err
+	}
+	if otherChanges {
+		configFileSum = readSum
+	}
+
+	return nil
+}
+
+// Get the log level of the monitor config
+func getLogLevel(configInstance baoConfig.MonitorConfig) slog.Level {
+	logLevel := configInstance.LogLevel
 	if logLevel == "" {
 		// Default log level if no log level was set
 		logLevel = "INFO"
 	}
 
+	var LogLevel slog.Level
+	LogLevel.UnmarshalText([]byte(logLevel))
+	return LogLevel
+}
+
+// Read the config file again, and replace the global config if it is valid.
+// A change in the log file path requires a restart.
+func reloadConfig() error {
+	slog.Info(fmt.Sprintf("Reloading config file %v", configFile))
+	var newConfig baoConfig.MonitorConfig
+	if !useK8sConfig && configLock == nil {
+		// Don't read a config that another monitor is changing
+		err := lockConfigFile()
+		if err != nil {
+			return err
+		}
+		defer unlockConfigFile()
+	}
+	err := readConfigFile(&newConfig)
+	if err != nil {
+		return err
+	}
+
+	if useK8sConfig {
+		config, err := getK8sConfig()
+		if err != nil {
+			return err
+		}
+		err = newConfig.MigrateK8sConfig(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	if newConfig.LogPath != globalConfig.LogPath {
+		slog.Warn(fmt.Sprintf("The change of logPath to %v is applied on restart", newConfig.LogPath))
+	}
+	baoLogLevel.Set(getLogLevel(newConfig))
+	globalConfig = newConfig
+	slog.Info("Config reload complete")
+
+	return nil
+}
+
+func setupCmd(cmd *cobra.Command, args []string) error {
+	// Hold the config lock until cleanCmd, so that the config changes of
+	// another monitor are not lost between reading and writing the config.
+	// The config is not written with --k8s.
+	if !useK8sConfig {
+		err := lockConfigFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Open config from file
+	err := readConfigFile(&globalConfig)
+	if err != nil {
+		return err
+	}
+
+	// Set default configuration for logs if no custum configs are given
+	logFile := globalConfig.LogPath
+
 	// Set default to stderr if no log file was specified.
 	logWriter = os.Stderr
 	if logFile != "" {
@@ -76,13 +296,12 @@ func setupCmd(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	var LogLevel slog.Level
-	LogLevel.UnmarshalText([]byte(logLevel))
+	baoLogLevel.Set(getLogLevel(globalConfig))
 	baoLogger = slog.New(slog.NewTextHandler(logWriter, &slog.HandlerOptions{
-		Level: LogLevel,
+		Level: baoLogLevel,
 	}))
 	slog.SetDefault(baoLogger)
-	slog.Debug(fmt.Sprintf("Set log level: %v", logLevel))
+	slog.Debug(fmt.Sprintf("Set log level: %v", baoLogLevel.Level()))
 
 	// If useK8sConfig is set to true, then it will override the following configs:
 	// ServerAddresses, Tokens, UnsealKeyShards
@@ -105,21 +324,22 @@ func setupCmd(cmd *cobra.Command, args []string) error {
 
 func cleanCmd(cmd *cobra.Command, args []string) error {
 	slog.Debug("Running cleanup...")
-	// Write back to configs from file only
-	if !useK8sConfig {
-		configWriter, err := os.OpenFile(configFile, os.O_WRONLY|os.O_TRUNC, 0644)
-		if err != nil {
-			return fmt.Errorf("error with opening config file to write in the changed configs: %v", err)
-		}
-		err = globalConfig.WriteYAMLMonitorConfig(configWriter)
-		if err != nil {
-			return fmt.Errorf("error with writing the changed configs: %v", err)
-		}
-		err = configWriter.Close()
+	// Write back to configs from file only.
+	// The run command writes its changes with updateConfigFile as they are
+	// made, and must not overwrite the config file with its config.
+	if !useK8sConfig && !configWrittenOnUpdate {
+		err := writeConfigFile()
 		if err != nil {
-			return fmt.Errorf("error with closing the changed config file: %v", err)
+			return err
 		}
 	}
+	unlockConfigFile()
+
+	if runLeader != nil {
+		runLeader.stop()
+		runLeader = nil
+	}
+	baoConfig.StopK8sAuthRenewal()
 
 	// Close the log file
 	if logWriter != os.Stderr {
@@ -135,7 +355,12 @@ func cleanCmd(cmd *cobra.Command, args []string) error {
 var RootCmd = &cobra.Command{
 	Use:   "baomon",
 	Short: "A monitor service for managing the secret servers",
-	Long:  `A monitor service for managing the secret servers`,
+	Long: `A monitor service for managing the secret servers
+
+The config is read in layers, each overriding the previous one: the defaults,
+the config file given with --config, the BAOMON_* environment variables, then
+the --set flags. The environment variables and the --set flags are not
+written to the config file.`,
 }
 
 func Execute() {
@@ -158,4 +383,9 @@ func init() {
 		"Set this to true if the monitor is run in a kubernetes pod")
 	RootCmd.PersistentFlags().StringVar(&kubeConfigPath, "kubeconfig", "/etc/kubernetes/admin.conf",
 		"The path for kubernetes config file (KUBECONFIG)")
+	RootCmd.PersistentFlags().StringArrayVar(&configOverrides, "set", nil,
+		"Override a config value as Key=Value, using the YAML key. Values of maps are given in YAML. "+
+			"The --set flags are the last layer of the config, applied over the config file and "+
+			"the BAOMON_* environment variables, e.g. BAOMON_LOG_LEVEL. "+
+			"The overrides are not written to the config file.")
 }
@@ -1,31 +1,303 @@
 package baoCommands
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	clientapi "github.com/openbao/openbao/api/v2"
+	baoConfig "github.com/michel-thebeau-WR/openbao-manager-go/baomon/config"
 	"github.com/spf13/cobra"
 	"k8s.io/client-go/rest"
 )
 
 var waitInterval int
+var waitIntervalFlagSet bool
+
+// Default number of servers checked at the same time
+var defaultMaxConcurrency int = 5
+
+// Default request timeout of the api client, used when Timeout is negative
+var defaultRequestTimeout time.Duration = 60 * time.Second
+
+// Outcomes of checking a server in a run cycle
+const (
+	hostActive         = "active"
+	hostStandby        = "standby"
+	hostUnsealed       = "unsealed"
+	hostSealed         = "sealed"
+	hostNotInitialized = "not initialized"
+	hostUnsealFailed   = "unseal failed"
+	hostCheckFailed    = "health check failed"
+	hostClientFailed   = "client setup failed"
+)
+
+// The result of checking a server in a run cycle
+type hostCheckResult struct {
+	Host           string
+	Status         string
+	Err            error
+	Duration       time.Duration
+	HealthDuration time.Duration
+}
+
+// Check the health of the server on host, and unseal it if it is sealed
+// and unseal is true. The whole check must complete before the deadline of ctx.
+func checkAndUnsealHost(ctx context.Context, host string, unseal bool) (result hostCheckResult) {
+	start := time.Now()
+	result.Host = host
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	slog.Debug(fmt.Sprintf("Creating client for host %v", host))
+	client, err := globalConfig.SetupClient(host)
+	if err != nil {
+		result.Status = hostClientFailed
+		result.Err = err
+		return result
+	}
+
+	slog.Debug(fmt.Sprintf("Checking current health status for host %v", host))
+	healthStart := time.Now()
+	healthStatus, err := checkHealthWithContext(ctx, host, client)
+	result.HealthDuration = time.Since(healthStart)
+	if err != nil {
+		result.Status = hostCheckFailed
+		result.Err = err
+		return result
+	}
+	healthPrint, err := json.Marshal(healthStatus)
+	if err == nil {
+		slog.Debug(fmt.Sprintf("health check result for host %v: %v", host, string(healthPrint)))
+	}
+
+	switch {
+	case !healthStatus.Initialized:
+		result.Status = hostNotInitialized
+	case healthStatus.Sealed && (!unseal || !stillLeader()):
+		result.Status = hostSealed
+		slog.Debug(fmt.Sprintf("Server on host %v is sealed. Leaving the unseal to the leader.", host))
+	case healthStatus.Sealed:
+		slog.Info(fmt.Sprintf("Server is sealed on host %v. Attempting to unseal.", host))
+		_, err := runUnsealWithContext(ctx, host, client)
+		if err != nil {
+			result.Status = hostUnsealFailed
+			result.Err = err
+			return result
+		}
+		result.Status = hostUnsealed
+		slog.Debug(fmt.Sprintf("Server on host %v is unsealed", host))
+	case healthStatus.Standby:
+		result.Status = hostStandby
+	default:
+		result.Status = hostActive
+	}
+
+	return result
+}
+
+// Get the request timeout of the api client.
+// A timeout of 0 means the requests have no deadline.
+func requestTimeout() time.Duration {
+	if globalConfig.Timeout >= 0 {
+		return time.Duration(globalConfig.Timeout) * time.Second
+	}
+	return defaultRequestTimeout
+}
+
+// Get the deadline for checking the server on host. Each request of the
+// check gets the request timeout: the health check, the seal status, and an
+// unseal call and a seal status for each unseal key shard of the host.
+func hostTimeout(host string) time.Duration {
+	requests := 2 + 2*len(globalConfig.GetUnsealShards(host))
+	return requestTimeout() * time.Duration(requests)
+}
+
+// Create the context for checking a single server.
+// A timeout of 0 means the check has no deadline.
+func hostContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Check all servers in ServerAddresses using a bounded pool of workers.
+// Each server gets its own deadline, so a slow server does not delay the others.
+// Sealed servers are only unsealed if unseal is true.
+func runCheckCycle(ctx context.Context, unseal bool) []hostCheckResult {
+	hosts := slices.Sorted(maps.Keys(globalConfig.ServerAddresses))
+	results := make([]hostCheckResult, len(hosts))
+
+	workers := defaultMaxConcurrency
+	if globalConfig.MaxConcurrency > 0 {
+		workers = globalConfig.MaxConcurrency
+	}
+	workers = min(workers, len(hosts))
+
+	slog.Debug(fmt.Sprintf("Checking %v servers with %v workers", len(hosts), workers))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				hostCtx, cancel := hostContext(ctx, hostTimeout(hosts[i]))
+				results[i] = checkAndUnsealHost(hostCtx, hosts[i], unseal)
+				cancel()
+			}
+		}()
+	}
+	for i := range hosts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// The status of each server in the previous run cycle
+var lastCycleStatus = make(map[string]string)
+
+// Log what happened to each server during a run cycle.
+// The summary is logged at the Debug level when no server changed status.
+func logCycleSummary(results []hostCheckResult) {
+	summary := make([]string, 0, len(results))
+	changed := len(results) != len(lastCycleStatus)
+	cycleStatus := make(map[string]string, len(results))
+	for _, result := range results {
+		summary = append(summary, fmt.Sprintf("%v: %v (%v)",
+			result.Host, result.Status, result.Duration.Round(time.Millisecond)))
+		if result.Err != nil {
+			slog.Error(fmt.Sprintf("%v on host %v: %v", result.Status, result.Host, result.Err))
+		}
+		if lastCycleStatus[result.Host] != result.Status || result.Status == hostUnsealed {
+			changed = true
+		}
+		cycleStatus[result.Host] = result.Status
+	}
+	lastCycleStatus = cycleStatus
+
+	message := fmt.Sprintf("Checked %v servers: %v", len(results), strings.Join(summary, ", "))
+	if changed {
+		slog.Info(message)
+	} else {
+		slog.Debug(message)
+	}
+}
+
+// Start the server pod watcher when WatchPods is set in the global config,
+// and replace it when the pod settings changed since it was started.
+// Returns the watcher to use, or nil when the pods are listed each cycle.
+func updatePodWatcher(podWatcher *baoConfig.PodWatcher, k8sconfig *rest.Config) (*baoConfig.PodWatcher, error) {
+	if podWatcher != nil {
+		if globalConfig.WatchPods && !podWatcher.SettingsChanged(&globalConfig) {
+			return podWatcher, nil
+		}
+		slog.Info("The pod discovery settings changed. Stopping the server pod watcher.")
+		podWatcher.Stop()
+	}
+	if !useK8sConfig || !globalConfig.WatchPods {
+		return nil, nil
+	}
+
+	newWatcher, err := globalConfig.NewPodWatcher(k8sconfig)
+	if err != nil {
+		return nil, err
+	}
+	err = newWatcher.Start()
+	if err != nil {
+		newWatcher.Stop()
+		return nil, err
+	}
+	return newWatcher, nil
+}
+
+// Use the WaitInterval of the config, if it is set, unless the
+// --waitInterval flag is given
+func applyWaitInterval() {
+	if waitIntervalFlagSet {
+		return
+	}
+	if globalConfig.WaitInterval != 0 {
+		waitInterval = globalConfig.WaitInterval
+	}
+}
+
+// Handle the signals received by the run command.
+// SIGINT and SIGTERM stop the run loop after the current cycle, and
+// SIGHUP requests a config reload. A second stop signal exits immediately.
+func handleSignals(sigCh <-chan os.Signal, stop context.CancelFunc, reloadCh chan<- struct{}) {
+	stopping := false
+	for sig := range sigCh {
+		switch sig {
+		case syscall.SIGHUP:
+			slog.Info("Received SIGHUP. The config will be reloaded.")
+			select {
+			case reloadCh <- struct{}{}:
+			default:
+				// A reload is already pending
+			}
+		default:
+			if stopping {
+				slog.Error(fmt.Sprintf("Received %v again. Exiting immediately.", sig))
+				os.Exit(1)
+			}
+			slog.Info(fmt.Sprintf("Received %v. Stopping after the current cycle.", sig))
+			stopping = true
+			stop()
+		}
+	}
+}
 
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "keep unsealing the servers",
 	Long: `Run a loop which detects if any servers are sealed, then if any are
-attempt to unseal.`,
+attempt to unseal.
+
+The non-root tokens in Tokens are renewed before they expire, and their
+duration is updated to the lease granted. A token that the servers report
+as expired or revoked on several checks in a row is removed, except the
+manager token. These token changes are written over the current content of
+the config file; the run command does not write back the rest of its config,
+on exit either. With SnapshotInterval set in the
+config, a raft snapshot is saved to SnapshotDirectory at each interval.
+When the discovery of the server pods fails, the servers found by the last
+discovery are checked.
+
+On SIGINT or SIGTERM, the current cycle is completed and the command exits
+with status 0 after the cleanup. The config file is reloaded on SIGHUP, and
+when the config file or the certificate files change. A config that fails
+the validation is rejected, and the current config is kept.
+
+With LeaderElection set in the config, several monitors can run at the same
+time. Only the leader unseals the servers, removes raft peers, renews the
+tokens, saves the snapshots and writes the config. The other monitors keep
+checking the servers and serving the metrics and probes. A kubernetes Lease
+is used with --k8s, otherwise a lock file.`,
 	PersistentPreRunE:  setupCmd,
 	PersistentPostRunE: cleanCmd,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		slog.Debug("Action: run")
-		if globalConfig.WaitInterval != 0 {
-			waitInterval = globalConfig.WaitInterval
-		}
+		// The run command does not hold the config lock taken by setupCmd,
+		// so that other commands can change the config while it runs.
+		// The lock is taken again for each read and write of the config.
+		unlockConfigFile()
+		configWrittenOnUpdate = true
+		waitIntervalFlagSet = cmd.Flags().Changed("waitInterval")
+		applyWaitInterval()
 
 		var k8sconfig *rest.Config = nil
 		var err error = nil
@@ -37,53 +309,153 @@ attempt to unseal.`,
 			}
 		}
 
-		for {
-			// If config was pulled from k8s, repull each time to reset the list of adresses,
-			// in case any of them changed
+		if globalConfig.LeaderElection {
 			if useK8sConfig {
-				err := globalConfig.MigratePodConfig(k8sconfig)
+				runLeader, err = startLeaseElection(k8sconfig)
 				if err != nil {
 					return err
 				}
+			} else {
+				runLeader = startFileLockElection()
+			}
+			// The leadership is released by cleanCmd
+		}
+
+		// Watch the server pods for changes instead of listing them each cycle
+		podWatcher, err := updatePodWatcher(nil, k8sconfig)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if podWatcher != nil {
+				podWatcher.Stop()
+			}
+		}()
+
+		httpServers, err := startHTTPServers()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			for _, server := range httpServers {
+				server.Close()
 			}
+		}()
+		runStatus.setStarted()
+
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		defer stopScheduledSnapshot()
+		reloadCh := make(chan struct{}, 1)
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(sigCh)
+		go handleSignals(sigCh, stop, reloadCh)
 
-			slog.Debug("Creating api clients for each server addresses..")
-			clientMap := make(map[string]*clientapi.Client, len(globalConfig.ServerAddresses))
-			for host := range maps.Keys(globalConfig.ServerAddresses) {
-				slog.Debug(fmt.Sprintf("Creating client for host %v", host))
-				newClient, err := globalConfig.SetupClient(host)
+		// Reload the config when it is changed, in addition to SIGHUP
+		var configChanged <-chan struct{} = nil
+		configWatch, err := startConfigWatcher()
+		if err != nil {
+			slog.Warn(fmt.Sprintf("The config is only reloaded on SIGHUP: %v", err))
+		} else {
+			defer configWatch.stop()
+			configChanged = configWatch.changed
+		}
+
+		wasLeader := isLeader()
+		for {
+			leader := isLeader()
+			if leader && !wasLeader && !useK8sConfig {
+				// The previous leader may have changed the config file
+				slog.Info("Reloading the config written by the previous leader")
+				err := reloadConfig()
 				if err != nil {
-					return fmt.Errorf("error occured during creating client for host %v: %v", host, err)
+					slog.Error(fmt.Sprintf("Config reload failed, keeping the current config: %v", err))
 				}
-				clientMap[host] = newClient
 			}
+			wasLeader = leader
+			recordLeaderMetrics(leader)
 
-			for host, client := range clientMap {
-				slog.Debug(fmt.Sprintf("Checking current health status for host %v", host))
-				healthStatus, err := checkHealth(host, client)
+			// The pod settings may have changed with a config reload
+			podWatcher, err = updatePodWatcher(podWatcher, k8sconfig)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Listing the server pods each cycle: %v", err))
+			}
+			var podChanged <-chan struct{} = nil
+			if podWatcher != nil {
+				podChanged = podWatcher.Changed()
+			}
+
+			// If config was pulled from k8s, repull each time to reset the list of adresses,
+			// in case any of them changed
+			var discoveryErr error = nil
+			if podWatcher != nil {
+				globalConfig.ServerAddresses = podWatcher.ServerAddresses()
+				recordDiscoveryMetrics(len(globalConfig.ServerAddresses), nil)
+			} else if useK8sConfig {
+				discoveryErr = globalConfig.MigratePodConfig(k8sconfig)
+				recordDiscoveryMetrics(len(globalConfig.ServerAddresses), discoveryErr)
+				runStatus.setK8sReady(discoveryErr == nil)
+				if discoveryErr != nil {
+					// Keep checking the servers found by the last discovery
+					slog.Error(fmt.Sprintf("Server discovery failed, checking the last discovered servers: %v", discoveryErr))
+				}
+			} else {
+				recordDiscoveryMetrics(len(globalConfig.ServerAddresses), nil)
+			}
+
+			// The cycle is not cancelled on shutdown, so that an unseal
+			// attempt in progress can complete. The leadership is checked
+			// again before each step that changes the servers, since it can
+			// be lost during the cycle.
+			results := runCheckCycle(context.WithoutCancel(ctx), leader)
+			logCycleSummary(results)
+			recordCycleMetrics(results, discoveryErr)
+			runStatus.setCycleComplete(results)
+
+			// Remove raft peers whose servers are gone
+			if leader && stillLeader() && globalConfig.RaftPeerGracePeriod > 0 {
+				gracePeriod := time.Duration(globalConfig.RaftPeerGracePeriod) * time.Second
+				err := pruneRaftPeers(gracePeriod, globalConfig.RaftPruneDryRun)
 				if err != nil {
-					slog.Error(fmt.Sprintf("error occured during check health for host %v: %v", host, err))
-					// skip to next host if an error occured
-					continue
+					slog.Error(fmt.Sprintf("error occured during removing dead raft peers: %v", err))
 				}
-				healthPrint, err := json.Marshal(healthStatus)
+			}
+
+			// Renew the tokens before they expire
+			if leader && stillLeader() {
+				err := manageTokens(results)
 				if err != nil {
-					slog.Error(fmt.Sprintf("error occured parsing check health result for host %v: %v", host, err))
-					continue
+					slog.Error(fmt.Sprintf("error occured during renewing the tokens: %v", err))
 				}
-				slog.Debug(fmt.Sprintf("health check result: %v", string(healthPrint)))
-				if healthStatus.Sealed {
-					slog.Info(fmt.Sprintf("Server is sealed on host %v. Attempting to unseal.", host))
-					_, err := runUnseal(host, client)
-					if err != nil {
-						slog.Error(fmt.Sprintf("error occured during unseal on host %v: %v", host, err))
-						continue
-					}
+			}
+
+			// Save a raft snapshot when one is due
+			if leader && stillLeader() {
+				err := runScheduledSnapshot(ctx)
+				if err != nil {
+					slog.Error(fmt.Sprintf("error occured during the scheduled snapshot: %v", err))
 				}
-				slog.Debug(fmt.Sprintf("Server on host %v is unsealed", host))
 			}
+
 			slog.Debug(fmt.Sprintf("Unseal check complete. Waiting %v seconds until the next check...", waitInterval))
-			time.Sleep(time.Duration(waitInterval) * time.Second)
+			select {
+			case <-ctx.Done():
+				slog.Info("The run loop has stopped.")
+				return nil
+			case <-reloadCh:
+				err := reloadConfig()
+				if err != nil {
+					slog.Error(fmt.Sprintf("Config reload failed, keeping the current config: %v", err))
+				} else {
+					applyWaitInterval()
+				}
+			case <-configChanged:
+				configWatch.applyChanges()
+			case <-podChanged:
+				slog.Info("Server pods changed. Checking the servers now.")
+			case <-time.After(time.Duration(waitInterval) * time.Second):
+			}
 		}
 	},
 }
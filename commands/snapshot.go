@@ -0,0 +1,355 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	clientapi "github.com/openbao/openbao/api/v2"
+	"github.com/spf13/cobra"
+)
+
+var restoreForce bool
+
+// Names of the scheduled snapshots: <prefix><timestamp><suffix>
+var snapshotPrefix string = "baomon-snapshot-"
+var snapshotSuffix string = ".snap"
+var snapshotTimeFormat string = "20060102T150405Z"
+var checksumSuffix string = ".sha256"
+
+// Time of the last scheduled snapshot of the run command
+var lastSnapshot time.Time
+
+// Time allowed for saving or restoring a snapshot, which can take much longer
+// than the other requests on a large database
+var snapshotTimeout time.Duration = 30 * time.Minute
+
+// A scheduled snapshot being saved in the background
+type backgroundSnapshot struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// The last scheduled snapshot of the run command
+var scheduledSnapshot *backgroundSnapshot
+
+// Write the checksum file of a snapshot, in the format of sha256sum
+func writeChecksumFile(path string, checksum string) error {
+	content := fmt.Sprintf("%v  %v\n", checksum, filepath.Base(path))
+	return os.WriteFile(path+checksumSuffix, []byte(content), 0600)
+}
+
+// Check a snapshot against its checksum file, if there is one
+func verifyChecksumFile(path string) error {
+	content, err := os.ReadFile(path + checksumSuffix)
+	if os.IsNotExist(err) {
+		slog.Warn(fmt.Sprintf("No checksum file for snapshot %v. Skipping the verification.", path))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the checksum file: %v", err)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return fmt.Errorf("the checksum file %v is empty", path+checksumSuffix)
+	}
+
+	snapshotFile, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open the snapshot: %v", err)
+	}
+	defer snapshotFile.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, snapshotFile)
+	if err != nil {
+		return fmt.Errorf("unable to read the snapshot: %v", err)
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != fields[0] {
+		return fmt.Errorf("the checksum %v of snapshot %v does not match %v", checksum, path, fields[0])
+	}
+
+	return nil
+}
+
+// Set up an authenticated client for the active server
+func setupSnapshotClient() (*clientapi.Client, string, error) {
+	host, err := findActiveServer()
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := globalConfig.SetupAuthClient(host)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, host, nil
+}
+
+// Save a raft snapshot from the active server to path, along with its
+// checksum file.
+func saveSnapshot(ctx context.Context, path string) error {
+	client, host, err := setupSnapshotClient()
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(ctx, client, host, path)
+}
+
+// Write a raft snapshot from host to path, along with its checksum file.
+// The snapshot is written to a temporary file first, so that path never
+// holds a partial snapshot, and the checksum file is written once path
+// holds the snapshot. The download is bound by snapshotTimeout rather than
+// the timeout of the requests.
+func writeSnapshot(ctx context.Context, client *clientapi.Client, host string, path string) error {
+	snapshotFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("unable to create the snapshot file: %v", err)
+	}
+	defer os.Remove(snapshotFile.Name())
+
+	slog.Debug(fmt.Sprintf("Saving a raft snapshot from host %v to %v", host, path))
+	client.SetClientTimeout(snapshotTimeout)
+	hash := sha256.New()
+	err = client.Sys().RaftSnapshotWithContext(ctx, io.MultiWriter(snapshotFile, hash))
+	if err != nil {
+		snapshotFile.Close()
+		return fmt.Errorf("error during call to raft snapshot: %v", err)
+	}
+	err = snapshotFile.Sync()
+	if err != nil {
+		snapshotFile.Close()
+		return fmt.Errorf("unable to write the snapshot file: %v", err)
+	}
+	err = snapshotFile.Close()
+	if err != nil {
+		return fmt.Errorf("unable to write the snapshot file: %v", err)
+	}
+
+	err = os.Rename(snapshotFile.Name(), path)
+	if err != nil {
+		return fmt.Errorf("unable to write the snapshot file: %v", err)
+	}
+	err = writeChecksumFile(path, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return fmt.Errorf("unable to write the checksum file: %v", err)
+	}
+
+	return nil
+}
+
+// Restore a raft snapshot from path on the active server. The upload is bound
+// by snapshotTimeout rather than the timeout of the other requests.
+func restoreSnapshot(path string, force bool) error {
+	err := verifyChecksumFile(path)
+	if err != nil {
+		return err
+	}
+	host, err := findActiveServer()
+	if err != nil {
+		return err
+	}
+	client, err := globalConfig.SetupAuthClient(host)
+	if err != nil {
+		return err
+	}
+
+	snapshotFile, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open the snapshot: %v", err)
+	}
+	defer snapshotFile.Close()
+
+	slog.Debug(fmt.Sprintf("Restoring the raft snapshot %v on host %v", path, host))
+	client.SetClientTimeout(snapshotTimeout)
+	err = client.Sys().RaftSnapshotRestore(snapshotFile, force)
+	if err != nil {
+		return fmt.Errorf("error during call to raft snapshot restore: %v", err)
+	}
+
+	return nil
+}
+
+// Remove the scheduled snapshots in dir beyond count, or older than maxAge,
+// along with their checksum files. A count or maxAge of 0 is no limit.
+func pruneSnapshots(dir string, count int, maxAge time.Duration, now time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("unable to list the snapshot directory: %v", err)
+	}
+
+	type snapshot struct {
+		name    string
+		takenAt time.Time
+	}
+	var snapshots []snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix)
+		takenAt, err := time.Parse(snapshotTimeFormat, timestamp)
+		if err != nil {
+			// Not a scheduled snapshot
+			continue
+		}
+		snapshots = append(snapshots, snapshot{name: name, takenAt: takenAt})
+	}
+	// Newest first
+	slices.SortFunc(snapshots, func(a, b snapshot) int {
+		return b.takenAt.Compare(a.takenAt)
+	})
+
+	for i, snapshot := range snapshots {
+		tooMany := count > 0 && i >= count
+		tooOld := maxAge > 0 && now.Sub(snapshot.takenAt) > maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		path := filepath.Join(dir, snapshot.name)
+		slog.Info(fmt.Sprintf("Removing the old snapshot %v", path))
+		err := os.Remove(path)
+		if err != nil {
+			return fmt.Errorf("unable to remove the old snapshot: %v", err)
+		}
+		err = os.Remove(path + checksumSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove the checksum file of the old snapshot: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Take a scheduled snapshot if SnapshotInterval has passed since the last
+// one, then apply the retention. The snapshot is saved in the background,
+// so that it does not hold up the checks of the servers, and a snapshot is
+// not started while the previous one is still running.
+func runScheduledSnapshot(ctx context.Context) error {
+	interval := time.Duration(globalConfig.SnapshotInterval) * time.Second
+	if interval <= 0 || time.Since(lastSnapshot) < interval {
+		return nil
+	}
+	if scheduledSnapshot != nil {
+		select {
+		case <-scheduledSnapshot.done:
+		default:
+			slog.Warn("The previous scheduled snapshot is still running. Skipping this one.")
+			return nil
+		}
+	}
+	// Don't retry a failed snapshot before the next interval
+	now := time.Now().UTC()
+	lastSnapshot = now
+
+	client, host, err := setupSnapshotClient()
+	if err != nil {
+		snapshotFailuresMetric.Inc()
+		return err
+	}
+
+	// The goroutine does not read the global config, which can be reloaded
+	dir := globalConfig.SnapshotDirectory
+	count := globalConfig.SnapshotRetentionCount
+	maxAge := time.Duration(globalConfig.SnapshotRetentionAge) * time.Second
+	path := filepath.Join(dir, snapshotPrefix+now.Format(snapshotTimeFormat)+snapshotSuffix)
+
+	snapshotCtx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	scheduledSnapshot = &backgroundSnapshot{cancel: cancel, done: make(chan struct{})}
+	go func(done chan struct{}) {
+		defer close(done)
+		defer cancel()
+		err := writeSnapshot(snapshotCtx, client, host, path)
+		if err != nil {
+			snapshotFailuresMetric.Inc()
+			slog.Error(fmt.Sprintf("error occured during the scheduled snapshot: %v", err))
+			return
+		}
+		lastSnapshotMetric.Set(float64(now.Unix()))
+		slog.Info(fmt.Sprintf("Saved the raft snapshot %v", path))
+
+		err = pruneSnapshots(dir, count, maxAge, now)
+		if err != nil {
+			slog.Error(fmt.Sprintf("error occured during removing the old snapshots: %v", err))
+		}
+	}(scheduledSnapshot.done)
+
+	return nil
+}
+
+// Cancel the scheduled snapshot in progress, if any, and wait for it to stop
+func stopScheduledSnapshot() {
+	if scheduledSnapshot == nil {
+		return
+	}
+	scheduledSnapshot.cancel()
+	<-scheduledSnapshot.done
+}
+
+var snapshotSaveCmd = &cobra.Command{
+	Use:   "save File",
+	Short: "Save a raft snapshot",
+	Long: `Save a raft snapshot from the active server to File. A checksum file
+File.sha256 is written next to it.`,
+	Args:               cobra.ExactArgs(1),
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug(fmt.Sprintf("Action: snapshot save %v", args[0]))
+
+		cmd.SilenceUsage = true
+		err := saveSnapshot(context.Background(), args[0])
+		if err != nil {
+			return fmt.Errorf("snapshot save failed with error: %v", err)
+		}
+		slog.Info(fmt.Sprintf("Snapshot saved to %v", args[0]))
+		return nil
+	},
+}
+
+var snapshotRestoreCmd = &cobra.Command{
+	Use:   "restore File",
+	Short: "Restore a raft snapshot",
+	Long: `Restore the raft snapshot in File on the active server. If File.sha256
+exists, the snapshot is checked against it first.`,
+	Args:               cobra.ExactArgs(1),
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug(fmt.Sprintf("Action: snapshot restore %v", args[0]))
+
+		cmd.SilenceUsage = true
+		err := restoreSnapshot(args[0], restoreForce)
+		if err != nil {
+			return fmt.Errorf("snapshot restore failed with error: %v", err)
+		}
+		slog.Info(fmt.Sprintf("Snapshot restored from %v", args[0]))
+		return nil
+	},
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and restore raft snapshots",
+	Long:  "Save and restore raft snapshots using the active server",
+}
+
+func init() {
+	snapshotRestoreCmd.Flags().BoolVar(&restoreForce, "force", false, "Restore a snapshot taken from a different cluster")
+	snapshotCmd.AddCommand(snapshotSaveCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	RootCmd.AddCommand(snapshotCmd)
+}
@@ -0,0 +1,271 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	yaml "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+var statusOutput string
+
+// Output formats of the status command
+var statusOutputFormats = []string{"table", "json", "yaml"}
+
+// The status of a server listed in ServerAddresses
+type serverStatus struct {
+	Host            string `json:"host" yaml:"host"`
+	Cluster         string `json:"cluster" yaml:"cluster"`
+	Address         string `json:"address" yaml:"address"`
+	Initialized     bool   `json:"initialized" yaml:"initialized"`
+	Sealed          bool   `json:"sealed" yaml:"sealed"`
+	Standby         bool   `json:"standby" yaml:"standby"`
+	Version         string `json:"version" yaml:"version"`
+	RaftRole        string `json:"raft_role" yaml:"raft_role"`
+	UnsealProgress  int    `json:"unseal_progress" yaml:"unseal_progress"`
+	UnsealThreshold int    `json:"unseal_threshold" yaml:"unseal_threshold"`
+	Error           string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// The raft configuration of a cluster
+type raftStatus struct {
+	Cluster string       `json:"cluster" yaml:"cluster"`
+	Leader  string       `json:"leader" yaml:"leader"`
+	Peers   []raftServer `json:"peers" yaml:"peers"`
+	Error   string       `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type clusterStatus struct {
+	Servers []serverStatus `json:"servers" yaml:"servers"`
+	Raft    []raftStatus   `json:"raft" yaml:"raft"`
+}
+
+// Get the status of the server on dnshost.
+// Errors are recorded in the status, so that the other servers are still listed.
+func getServerStatus(dnshost string) serverStatus {
+	status := serverStatus{
+		Host:    dnshost,
+		Cluster: globalConfig.ServerClusters[dnshost],
+	}
+	address, err := globalConfig.GetAPIAddress(dnshost)
+	if err == nil {
+		status.Address = address
+	}
+
+	client, err := globalConfig.SetupClient(dnshost)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	healthResult, err := checkHealth(dnshost, client)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Initialized = healthResult.Initialized
+	status.Sealed = healthResult.Sealed
+	status.Standby = healthResult.Standby
+	status.Version = healthResult.Version
+
+	sealStatus, err := client.Sys().SealStatus()
+	if err != nil {
+		status.Error = fmt.Sprintf("error during call to seal status: %v", err)
+		return status
+	}
+	status.UnsealProgress = sealStatus.Progress
+	status.UnsealThreshold = sealStatus.T
+
+	return status
+}
+
+// Get the raft configuration of a cluster from its active server
+func getRaftStatus(cluster string, activeHost string) raftStatus {
+	status := raftStatus{Cluster: cluster}
+	client, err := globalConfig.SetupAuthClient(activeHost)
+	if err != nil {
+		status.Error = fmt.Sprintf("unable to read the raft configuration: %v", err)
+		return status
+	}
+	raftConfig, err := getRaftConfiguration(client)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Peers = raftConfig.Servers
+	for _, server := range raftConfig.Servers {
+		if server.Leader {
+			status.Leader = server.NodeID
+		}
+	}
+
+	return status
+}
+
+// Get the role of the server on dnshost in the raft configuration
+func raftRole(dnshost string, peers []raftServer) string {
+	for _, server := range peers {
+		if !raftServerMatchesHost(server, dnshost) {
+			continue
+		}
+		switch {
+		case server.Leader:
+			return "leader"
+		case server.Voter:
+			return "follower"
+		default:
+			return "non-voter"
+		}
+	}
+	return ""
+}
+
+// Get the status of all servers in ServerAddresses, and the raft
+// configuration of each cluster.
+func getClusterStatus() clusterStatus {
+	status := clusterStatus{
+		Servers: []serverStatus{},
+		Raft:    []raftStatus{},
+	}
+	activeHosts := make(map[string]string)
+	for _, host := range slices.Sorted(maps.Keys(globalConfig.ServerAddresses)) {
+		server := getServerStatus(host)
+		if server.Error != "" {
+			slog.Warn(fmt.Sprintf("unable to get the status of host %v: %v", host, server.Error))
+		}
+		if _, ok := activeHosts[server.Cluster]; !ok &&
+			server.Error == "" && server.Initialized && !server.Sealed && !server.Standby {
+			activeHosts[server.Cluster] = host
+		}
+		status.Servers = append(status.Servers, server)
+	}
+
+	for _, cluster := range slices.Sorted(maps.Keys(activeHosts)) {
+		raft := getRaftStatus(cluster, activeHosts[cluster])
+		if raft.Error != "" {
+			slog.Warn(fmt.Sprintf("unable to get the raft status of cluster %q: %v", cluster, raft.Error))
+		}
+		for i := range status.Servers {
+			if status.Servers[i].Cluster == cluster {
+				status.Servers[i].RaftRole = raftRole(status.Servers[i].Host, raft.Peers)
+			}
+		}
+		status.Raft = append(status.Raft, raft)
+	}
+
+	return status
+}
+
+// Print the cluster status as tables of servers and raft peers
+func printStatusTable(out io.Writer, status clusterStatus) error {
+	orDash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCLUSTER\tINITIALIZED\tSEALED\tSTANDBY\tVERSION\tRAFT ROLE\tUNSEAL PROGRESS\tERROR")
+	for _, server := range status.Servers {
+		progress := "-"
+		if server.Sealed {
+			progress = fmt.Sprintf("%v/%v", server.UnsealProgress, server.UnsealThreshold)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			server.Host, orDash(server.Cluster), server.Initialized, server.Sealed, server.Standby,
+			orDash(server.Version), orDash(server.RaftRole), progress, orDash(server.Error))
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, raft := range status.Raft {
+		fmt.Fprintln(out)
+		if raft.Cluster != "" {
+			fmt.Fprintf(out, "Cluster: %v\n", raft.Cluster)
+		}
+		if raft.Error != "" {
+			fmt.Fprintf(out, "Raft: %v\n", raft.Error)
+			continue
+		}
+		fmt.Fprintf(out, "Raft leader: %v\n", orDash(raft.Leader))
+		fmt.Fprintln(w, "NODE ID\tADDRESS\tLEADER\tVOTER")
+		for _, peer := range raft.Peers {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", peer.NodeID, peer.Address, peer.Leader, peer.Voter)
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Print the cluster status in the requested format
+func printStatus(out io.Writer, status clusterStatus, format string) error {
+	switch format {
+	case "table":
+		return printStatusTable(out, status)
+	case "json":
+		statusPrint, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return fmt.Errorf("unable to marshal the status: %v", err)
+		}
+		fmt.Fprintln(out, string(statusPrint))
+	case "yaml":
+		statusPrint, err := yaml.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("unable to marshal the status: %v", err)
+		}
+		fmt.Fprint(out, string(statusPrint))
+	default:
+		return fmt.Errorf("unknown output format %v. Available formats: %v",
+			format, strings.Join(statusOutputFormats, ", "))
+	}
+
+	return nil
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of all servers",
+	Long: `Show whether each server in ServerAddresses is initialized, sealed or
+in standby, its version, raft role and unseal progress. The raft leader and
+peers are read from the active server of each cluster, which requires an
+authenticated token with read on sys/storage/raft/configuration.`,
+	Args:               cobra.NoArgs,
+	PersistentPreRunE:  setupCmd,
+	PersistentPostRunE: cleanCmd,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Action: status")
+		if !slices.Contains(statusOutputFormats, statusOutput) {
+			return fmt.Errorf("unknown output format %v. Available formats: %v",
+				statusOutput, strings.Join(statusOutputFormats, ", "))
+		}
+
+		cmd.SilenceUsage = true
+		status := getClusterStatus()
+		return printStatus(os.Stdout, status, statusOutput)
+	},
+}
+
+func init() {
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "table", "output format: table, json or yaml")
+	RootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,242 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoCommands
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	baoConfig "github.com/michel-thebeau-WR/openbao-manager-go/baomon/config"
+	clientapi "github.com/openbao/openbao/api/v2"
+)
+
+// Time between the checks of the tokens in Tokens by the run command
+var tokenCheckInterval time.Duration = 60 * time.Second
+var lastTokenCheck time.Time
+
+// Tokens that were already reported as not renewable or near their max TTL
+var tokenWarned = make(map[string]bool)
+
+// Number of checks in a row that must report a token as expired or revoked
+// before it is removed from Tokens
+var tokenPruneChecks int = 3
+
+// Number of checks in a row that reported each token as expired or revoked
+var tokenInvalidChecks = make(map[string]int)
+
+// Messages of the server for a token that is expired, revoked or unknown
+var invalidTokenMessages = []string{"bad token", "invalid token"}
+
+// Check if the error says that the token is expired, revoked or unknown.
+// Other errors, such as a permission denied by a policy, do not mean that
+// the token is invalid.
+func tokenInvalid(err error) bool {
+	var respErr *clientapi.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusForbidden {
+		return false
+	}
+	for _, message := range respErr.Errors {
+		for _, invalidMessage := range invalidTokenMessages {
+			if strings.Contains(message, invalidMessage) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Warn about a token once, until its state changes
+func warnToken(tokenID string, message string) {
+	if tokenWarned[tokenID] {
+		slog.Debug(message)
+		return
+	}
+	tokenWarned[tokenID] = true
+	slog.Warn(message)
+}
+
+// Look up the TTL of a token, and renew it once less than half of its lease
+// duration remains. Returns whether the token is expired or revoked.
+// The Duration of the token is the increment requested on each renewal, and
+// is updated to the lease duration granted by the renewal. The TTL observed
+// is reported by the token_ttl_seconds metric.
+func renewToken(client *clientapi.Client, tokenID string, token *baoConfig.Token) (bool, error) {
+	client.SetToken(token.Key)
+	lookup, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		if tokenInvalid(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error during call to look up token %v: %v", tokenID, err)
+	}
+	ttl, err := lookup.TokenTTL()
+	if err != nil {
+		return false, fmt.Errorf("unable to read the TTL of token %v: %v", tokenID, err)
+	}
+	tokenTTLMetric.WithLabelValues(tokenID).Set(ttl.Seconds())
+	if ttl == 0 {
+		// The token does not expire
+		return false, nil
+	}
+
+	renewable, err := lookup.TokenIsRenewable()
+	if err != nil {
+		return false, fmt.Errorf("unable to read whether token %v is renewable: %v", tokenID, err)
+	}
+	if !renewable {
+		warnToken(tokenID, fmt.Sprintf("Token %v is not renewable and expires in %v", tokenID, ttl))
+		return false, nil
+	}
+
+	lease := time.Duration(token.Duration) * time.Second
+	if lease <= 0 {
+		lease = ttl
+	}
+	if ttl > lease/2 {
+		slog.Debug(fmt.Sprintf("Token %v expires in %v, not renewing yet", tokenID, ttl))
+		return false, nil
+	}
+
+	slog.Debug(fmt.Sprintf("Renewing token %v, which expires in %v", tokenID, ttl))
+	renewed, err := client.Auth().Token().RenewSelf(token.Duration)
+	if err != nil {
+		if tokenInvalid(err) {
+			return true, nil
+		}
+		tokenRenewalFailuresMetric.WithLabelValues(tokenID).Inc()
+		return false, fmt.Errorf("error during call to renew token %v: %v", tokenID, err)
+	}
+	if renewed == nil || renewed.Auth == nil {
+		tokenRenewalFailuresMetric.WithLabelValues(tokenID).Inc()
+		return false, fmt.Errorf("empty response from renewing token %v", tokenID)
+	}
+	tokenRenewalsMetric.WithLabelValues(tokenID).Inc()
+	newLease := renewed.Auth.LeaseDuration
+	tokenTTLMetric.WithLabelValues(tokenID).Set(float64(newLease))
+
+	if token.Duration > 0 && newLease < token.Duration {
+		// The renewal was capped by the max TTL of the token
+		warnToken(tokenID, fmt.Sprintf("Token %v is near its max TTL and expires in %v",
+			tokenID, time.Duration(newLease)*time.Second))
+	} else {
+		delete(tokenWarned, tokenID)
+	}
+	slog.Info(fmt.Sprintf("Renewed token %v for %v", tokenID, time.Duration(newLease)*time.Second))
+	if newLease > 0 {
+		// A Duration of 0 marks the root token
+		token.Duration = newLease
+	}
+
+	return false, nil
+}
+
+// Store the renewed Durations and the removal of the tokens in the config
+// file. Only these tokens are changed in the config file, and a Duration is
+// only updated for a token that still has the same key. With --k8s, the
+// tokens that can be renewed or removed are not stored in the kubernetes
+// secrets.
+func storeTokens(renewed map[string]baoConfig.Token, removed []string) error {
+	if useK8sConfig {
+		return nil
+	}
+
+	return updateConfigFile(func(configInstance *baoConfig.MonitorConfig) {
+		for tokenID, token := range renewed {
+			fileToken, ok := configInstance.Tokens[tokenID]
+			if ok && fileToken.Key == token.Key {
+				fileToken.Duration = token.Duration
+				configInstance.Tokens[tokenID] = fileToken
+			}
+		}
+		for _, tokenID := range removed {
+			delete(configInstance.Tokens, tokenID)
+		}
+	})
+}
+
+// Renew the non-root tokens in Tokens and update their Duration, and remove
+// the tokens reported as expired or revoked on tokenPruneChecks checks in a
+// row. The tokens are checked at most once per tokenCheckInterval,
+// on a server that was found unsealed in the last run cycle.
+func manageTokens(results []hostCheckResult) error {
+	if time.Since(lastTokenCheck) < tokenCheckInterval {
+		return nil
+	}
+	host := ""
+	for _, result := range results {
+		if result.Status == hostActive || result.Status == hostStandby || result.Status == hostUnsealed {
+			host = result.Host
+			break
+		}
+	}
+	if host == "" {
+		return fmt.Errorf("no unsealed server to check the tokens on")
+	}
+	lastTokenCheck = time.Now()
+
+	client, err := globalConfig.SetupClient(host)
+	if err != nil {
+		return err
+	}
+
+	renewed := make(map[string]baoConfig.Token)
+	var removed []string
+	for _, tokenID := range slices.Sorted(maps.Keys(globalConfig.Tokens)) {
+		token := globalConfig.Tokens[tokenID]
+		if token.Duration == 0 {
+			// The root token does not expire
+			continue
+		}
+		invalid, err := renewToken(client, tokenID, &token)
+		if err != nil {
+			slog.Error(err.Error())
+			continue
+		}
+		if !invalid {
+			delete(tokenInvalidChecks, tokenID)
+			if token.Duration != globalConfig.Tokens[tokenID].Duration {
+				slog.Info(fmt.Sprintf("Updating the Duration of token %v to %v seconds", tokenID, token.Duration))
+				globalConfig.Tokens[tokenID] = token
+				renewed[tokenID] = token
+			}
+			continue
+		}
+
+		tokenInvalidChecks[tokenID]++
+		if tokenID == baoConfig.ManagerTokenID {
+			// The manager token is also stored in the manager secret
+			slog.Error(fmt.Sprintf("Token %v is expired or revoked. It is not removed automatically.", tokenID))
+			continue
+		}
+		if tokenInvalidChecks[tokenID] < tokenPruneChecks {
+			slog.Warn(fmt.Sprintf("Token %v is expired or revoked. It is removed after %v checks in a row.",
+				tokenID, tokenPruneChecks))
+			continue
+		}
+		slog.Error(fmt.Sprintf("Token %v is expired or revoked. Removing it from Tokens.", tokenID))
+		delete(globalConfig.Tokens, tokenID)
+		delete(tokenWarned, tokenID)
+		delete(tokenInvalidChecks, tokenID)
+		tokenTTLMetric.DeleteLabelValues(tokenID)
+		removed = append(removed, tokenID)
+	}
+
+	changed := len(renewed) > 0 || len(removed) > 0
+	if changed && !stillLeader() {
+		return fmt.Errorf("lost the leadership, not storing the changed tokens")
+	}
+	if changed {
+		return storeTokens(renewed, removed)
+	}
+	return nil
+}
@@ -1,10 +1,12 @@
 package baoCommands
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strings"
+	"slices"
 
 	baoConfig "github.com/michel-thebeau-WR/openbao-manager-go/baomon/config"
 	clientapi "github.com/openbao/openbao/api/v2"
@@ -12,56 +14,104 @@ import (
 )
 
 // A single instance of unseal.
-func tryUnseal(keyShard baoConfig.KeyShards, client *clientapi.Client) (*clientapi.SealStatusResponse, error) {
+func tryUnseal(ctx context.Context, keyShard baoConfig.KeyShards, client *clientapi.Client) (*clientapi.SealStatusResponse, error) {
 	slog.Debug("Attempting unseal...")
 	key := keyShard.Key
-	UnsealResult, err := client.Sys().Unseal(key)
+	UnsealResult, err := client.Sys().UnsealWithContext(ctx, key)
 	if err != nil {
-		return nil, fmt.Errorf("error with unseal call: %v", err)
+		return nil, fmt.Errorf("error with unseal call: %w", err)
 	}
 	slog.Debug("Unseal attempt successful")
 	return UnsealResult, nil
 }
 
-// run unseal on all keys associated with dnshost until unsealed.
+// run unseal on the keys of the cluster of dnshost until unsealed.
 func runUnseal(dnshost string, client *clientapi.Client) (*clientapi.SealStatusResponse, error) {
+	return runUnsealWithContext(context.Background(), dnshost, client)
+}
+
+func runUnsealWithContext(ctx context.Context, dnshost string, client *clientapi.Client) (*clientapi.SealStatusResponse, error) {
 	slog.Debug(fmt.Sprintf("Attempting to run unseal on host %v", dnshost))
 
 	slog.Debug("Checking if the server is already unsealed")
-	healthResult, err := checkHealth(dnshost, client)
+	sealStatus, err := client.Sys().SealStatusWithContext(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error during call to seal status: %v", err)
 	}
-	if !healthResult.Sealed {
+	if !sealStatus.Sealed {
 		return nil, fmt.Errorf("The server on host %v is already unsealed", dnshost)
 	}
 
-	tryCount := 1
-	for keyName, keyShard := range globalConfig.UnsealKeyShards {
-		// Don't use recovery keys
-		if !strings.Contains(keyName, "recovery") {
-			slog.Debug(fmt.Sprintf("Unseal attempt %v", tryCount))
-			UnsealResult, err := tryUnseal(keyShard, client)
-			if err != nil {
+	shardNames := globalConfig.GetUnsealShards(dnshost)
+	if len(shardNames) == 0 {
+		return nil, fmt.Errorf("no unseal key shards belong to the cluster of %v", dnshost)
+	}
+	slog.Debug(fmt.Sprintf("Using shards %v for host %v", shardNames, dnshost))
+
+	// The unseal progress of the server, and the shards submitted for it
+	progress := sealStatus.Progress
+	var submitted []string
+	var rejected []string
+	var failedCombinations [][]string
+	for tryCount, shardName := range shardNames {
+		if !stillLeader() {
+			return nil, fmt.Errorf("lost the leadership, leaving the unseal of host %v to the new leader", dnshost)
+		}
+		slog.Debug(fmt.Sprintf("Unseal attempt %v with shard %v", tryCount+1, shardName))
+		UnsealResult, err := tryUnseal(ctx, globalConfig.UnsealKeyShards[shardName], client)
+		if err != nil {
+			var respErr *clientapi.ResponseError
+			if !errors.As(err, &respErr) {
 				return nil, err
 			}
-			if !UnsealResult.Sealed {
-				slog.Debug("Unseal complete.")
-				return UnsealResult, nil
+			// Resync with the server. The server resets its progress when
+			// the combination of shards fails, which is not the fault of the
+			// last shard submitted.
+			UnsealResult, err = client.Sys().SealStatusWithContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("error during call to seal status: %v", err)
+			}
+			if progress > 0 && UnsealResult.Progress == 0 {
+				combination := append(slices.Clone(submitted), shardName)
+				slog.Warn(fmt.Sprintf("Host %v rejected the combination of shards %v", dnshost, combination))
+				failedCombinations = append(failedCombinations, combination)
+			} else {
+				slog.Warn(fmt.Sprintf("Shard %v was rejected by host %v: %v", shardName, dnshost, respErr))
+				rejected = append(rejected, shardName)
 			}
-			slog.Debug(fmt.Sprintf("The server is still sealed: threshold %v, progress %v", UnsealResult.T, UnsealResult.Progress))
-			tryCount++
+		} else if UnsealResult.Sealed {
+			submitted = append(submitted, shardName)
 		}
+
+		if !UnsealResult.Sealed {
+			if len(rejected) > 0 || len(failedCombinations) > 0 {
+				slog.Warn(fmt.Sprintf("Host %v unsealed, but rejected shards %v and shard combinations %v",
+					dnshost, rejected, failedCombinations))
+			}
+			slog.Debug("Unseal complete.")
+			return UnsealResult, nil
+		}
+		if UnsealResult.Progress == 0 || UnsealResult.Progress < len(submitted) {
+			// The progress was reset, the next shards start a new combination
+			submitted = nil
+		}
+		progress = UnsealResult.Progress
+		slog.Debug(fmt.Sprintf("The server is still sealed: threshold %v, progress %v", UnsealResult.T, UnsealResult.Progress))
 	}
 
+	if len(rejected) > 0 || len(failedCombinations) > 0 {
+		return nil, fmt.Errorf("unable to unseal host %v, rejected shards: %v, rejected shard combinations: %v",
+			dnshost, rejected, failedCombinations)
+	}
 	return nil, fmt.Errorf("exhausted all non-recovery keys associated with %v", dnshost)
 }
 
 var unsealCmd = &cobra.Command{
 	Use:   "unseal DNSHost",
 	Short: "Unseal a server",
-	Long: `Unseal the server hosted on DNSHost. It will use all
-non-recovery keys with its name on it to unseal.`,
+	Long: `Unseal the server hosted on DNSHost. It will use the non-recovery
+keys that belong to the cluster of DNSHost, in order, until the threshold
+is reached.`,
 	Args:               cobra.ExactArgs(1),
 	PersistentPreRunE:  setupCmd,
 	PersistentPostRunE: cleanCmd,
@@ -0,0 +1,324 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoConfig
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	clientapi "github.com/openbao/openbao/api/v2"
+)
+
+// Sources of the token set on the clients by SetupAuthClient
+const (
+	TokenSourceTokens     string = "tokens"
+	TokenSourceEnv        string = "env"
+	TokenSourceFile       string = "file"
+	TokenSourceKubernetes string = "kubernetes"
+)
+
+var tokenSources = []string{TokenSourceTokens, TokenSourceEnv, TokenSourceFile, TokenSourceKubernetes}
+
+// Default environment variable of the "env" token source
+var tokenEnv string = "BAO_TOKEN"
+
+// Default values for the kubernetes auth method
+var k8sAuthMount string = "kubernetes"
+var k8sAuthTokenPath string = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Time before a failed kubernetes auth login is tried again
+var k8sAuthRetryInterval time.Duration = 30 * time.Second
+
+// Part of the lease of a kubernetes auth token that cannot be renewed left
+// when a new login is done, so that the token does not expire while in use
+var k8sAuthExpiryMargin float64 = 0.1
+
+// A kubernetes auth login, shared by the clients of a cluster. The client
+// token is kept renewed until it reaches its max TTL. A token that cannot be
+// renewed is used until shortly before it expires.
+type k8sAuthLogin struct {
+	// Closed once the login is done. The other fields are set before.
+	done chan struct{}
+
+	token    string
+	err      error
+	failedAt time.Time
+	watcher  *clientapi.LifetimeWatcher
+
+	// When a new login is needed for a token that cannot be renewed.
+	// Zero when the token is renewed, or does not expire.
+	expiresAt time.Time
+}
+
+// The kubernetes auth logins, by auth mount, role and cluster.
+// The mutex only guards the map, and is not held during a login.
+var k8sAuthMutex sync.Mutex
+var k8sAuthLogins = make(map[string]*k8sAuthLogin)
+
+// Check if the login failed long enough ago to be tried again
+func (login *k8sAuthLogin) retryDue() bool {
+	select {
+	case <-login.done:
+		return login.err != nil && time.Since(login.failedAt) >= k8sAuthRetryInterval
+	default:
+		// The login is in progress
+		return false
+	}
+}
+
+// Check if the token of the login cannot be renewed and is about to expire
+func (login *k8sAuthLogin) expired() bool {
+	select {
+	case <-login.done:
+		return login.err == nil && !login.expiresAt.IsZero() && !time.Now().Before(login.expiresAt)
+	default:
+		// The login is in progress
+		return false
+	}
+}
+
+// Get the token source of the monitor config.
+// Without TokenSource, the credential created by the bootstrap command is
+// preferred over the root token.
+func (configInstance MonitorConfig) getTokenSource() string {
+	if configInstance.TokenSource != "" {
+		return configInstance.TokenSource
+	}
+	if _, ok := configInstance.Tokens[ManagerTokenID]; ok {
+		return TokenSourceTokens
+	}
+	if configInstance.K8sAuthRole != "" {
+		return TokenSourceKubernetes
+	}
+	return TokenSourceTokens
+}
+
+// Get the token from Tokens named TokenName, or the manager token, or the
+// root token if it was not revoked.
+func (configInstance MonitorConfig) getNamedToken() (string, error) {
+	if configInstance.TokenName != "" {
+		token, ok := configInstance.Tokens[configInstance.TokenName]
+		if !ok {
+			return "", fmt.Errorf("unable to find the token %v under Tokens", configInstance.TokenName)
+		}
+		return token.Key, nil
+	}
+	managerToken, err := configInstance.GetManagerToken()
+	if err == nil {
+		return managerToken, nil
+	}
+	if configInstance.RootTokenRevoked {
+		return "", fmt.Errorf("the root token was revoked, and there is no manager token")
+	}
+
+	return configInstance.GetRootToken()
+}
+
+// Get the token for the clients of dnshost from the token source
+func (configInstance MonitorConfig) GetClientToken(ctx context.Context, dnshost string, clientConfig *clientapi.Config) (string, error) {
+	source := configInstance.getTokenSource()
+	slog.Debug(fmt.Sprintf("Getting the token for host %v from source %v", dnshost, source))
+
+	switch source {
+	case TokenSourceTokens:
+		return configInstance.getNamedToken()
+	case TokenSourceEnv:
+		envName := tokenEnv
+		if configInstance.TokenEnv != "" {
+			envName = configInstance.TokenEnv
+		}
+		token := os.Getenv(envName)
+		if token == "" {
+			return "", fmt.Errorf("the environment variable %v is not set", envName)
+		}
+		return token, nil
+	case TokenSourceFile:
+		data, err := os.ReadFile(configInstance.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read the token file: %v", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", fmt.Errorf("the token file %v is empty", configInstance.TokenFile)
+		}
+		return token, nil
+	case TokenSourceKubernetes:
+		return configInstance.getK8sAuthToken(ctx, dnshost, clientConfig)
+	}
+
+	return "", fmt.Errorf("unknown token source %v", source)
+}
+
+// Get the path of the kubernetes auth method
+func (configInstance MonitorConfig) getK8sAuthMount() string {
+	if configInstance.K8sAuthMount != "" {
+		return strings.Trim(configInstance.K8sAuthMount, "/")
+	}
+	return k8sAuthMount
+}
+
+// Log in with the kubernetes auth method using the projected service
+// account token. The loginClient must not be used for anything else, as it
+// keeps renewing the resulting client token.
+func (configInstance MonitorConfig) k8sAuthLogin(ctx context.Context, loginClient *clientapi.Client) (*clientapi.Secret, error) {
+	tokenPath := k8sAuthTokenPath
+	if configInstance.K8sAuthTokenPath != "" {
+		tokenPath = configInstance.K8sAuthTokenPath
+	}
+	jwt, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the service account token: %v", err)
+	}
+
+	mount := configInstance.getK8sAuthMount()
+	slog.Debug(fmt.Sprintf("Logging in with the kubernetes auth role %v at auth/%v",
+		configInstance.K8sAuthRole, mount))
+	secret, err := loginClient.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%v/login", mount), map[string]interface{}{
+		"role": configInstance.K8sAuthRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error during call to kubernetes auth login: %v", err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("empty response from kubernetes auth login")
+	}
+
+	return secret, nil
+}
+
+// Get a client token from the kubernetes auth method for the cluster of
+// dnshost. The token is reused until it can no longer be renewed, or until
+// shortly before it expires if it cannot be renewed, then a new login is
+// done. A single login is done at a time for each cluster, and
+// the clients of the cluster wait for it until ctx is done. A failed login
+// is tried again after k8sAuthRetryInterval.
+func (configInstance MonitorConfig) getK8sAuthToken(ctx context.Context, dnshost string, clientConfig *clientapi.Config) (string, error) {
+	loginKey := strings.Join([]string{configInstance.getK8sAuthMount(), configInstance.K8sAuthRole,
+		configInstance.getCluster(dnshost)}, "/")
+
+	k8sAuthMutex.Lock()
+	login, ok := k8sAuthLogins[loginKey]
+	if ok && login.expired() {
+		slog.Info(fmt.Sprintf("The kubernetes auth token for host %v cannot be renewed and is about to expire. "+
+			"Logging in again.", dnshost))
+	}
+	if !ok || login.retryDue() || login.expired() {
+		login = &k8sAuthLogin{done: make(chan struct{})}
+		k8sAuthLogins[loginKey] = login
+		k8sAuthMutex.Unlock()
+		// The login is not cancelled with ctx, since other clients may be
+		// waiting for it. It is bounded by the timeout of the client.
+		configInstance.runK8sAuthLogin(context.WithoutCancel(ctx), loginKey, login, dnshost, clientConfig)
+	} else {
+		k8sAuthMutex.Unlock()
+	}
+
+	select {
+	case <-login.done:
+	case <-ctx.Done():
+		return "", fmt.Errorf("waiting for the kubernetes auth login: %v", ctx.Err())
+	}
+	if login.err != nil {
+		return "", login.err
+	}
+	return login.token, nil
+}
+
+// Log in with the kubernetes auth method for login, and start renewing the
+// client token. Done without holding k8sAuthMutex.
+func (configInstance MonitorConfig) runK8sAuthLogin(ctx context.Context, loginKey string, login *k8sAuthLogin,
+	dnshost string, clientConfig *clientapi.Config) {
+	loginClient, err := clientapi.NewClient(clientConfig)
+	if err != nil {
+		err = fmt.Errorf("error in creating new client: %v", err)
+	} else {
+		loginClient.ClearToken()
+		var secret *clientapi.Secret
+		secret, err = configInstance.k8sAuthLogin(ctx, loginClient)
+		if err == nil && secret.Auth.Renewable {
+			login.watcher, err = loginClient.NewLifetimeWatcher(&clientapi.LifetimeWatcherInput{Secret: secret})
+			if err != nil {
+				err = fmt.Errorf("unable to renew the kubernetes auth token: %v", err)
+			}
+		} else if err == nil && secret.Auth.LeaseDuration > 0 {
+			lease := time.Duration(secret.Auth.LeaseDuration) * time.Second
+			login.expiresAt = time.Now().Add(lease - time.Duration(float64(lease)*k8sAuthExpiryMargin))
+		}
+		if err == nil {
+			login.token = secret.Auth.ClientToken
+		}
+	}
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Kubernetes auth login failed for host %v, trying again in %v: %v",
+			dnshost, k8sAuthRetryInterval, err))
+		login.err = err
+		login.failedAt = time.Now()
+		close(login.done)
+		return
+	}
+	slog.Info(fmt.Sprintf("Logged in with the kubernetes auth role %v for host %v",
+		configInstance.K8sAuthRole, dnshost))
+	close(login.done)
+
+	if login.watcher == nil {
+		return
+	}
+	k8sAuthMutex.Lock()
+	defer k8sAuthMutex.Unlock()
+	if k8sAuthLogins[loginKey] != login {
+		// The renewal was stopped during the login
+		return
+	}
+	go login.watcher.Start()
+	go watchK8sAuthLogin(loginKey, login)
+}
+
+// Follow the renewal of a kubernetes auth token. Once the token can no longer
+// be renewed, it is forgotten so that the next client logs in again.
+func watchK8sAuthLogin(loginKey string, login *k8sAuthLogin) {
+	for {
+		select {
+		case err := <-login.watcher.DoneCh():
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Renewing the kubernetes auth token failed: %v", err))
+			} else {
+				slog.Info("The kubernetes auth token reached its max TTL. Logging in again on next use.")
+			}
+			k8sAuthMutex.Lock()
+			if k8sAuthLogins[loginKey] == login {
+				delete(k8sAuthLogins, loginKey)
+			}
+			k8sAuthMutex.Unlock()
+			return
+		case renewal := <-login.watcher.RenewCh():
+			slog.Debug(fmt.Sprintf("Renewed the kubernetes auth token at %v", renewal.RenewedAt))
+		}
+	}
+}
+
+// Stop renewing the kubernetes auth tokens
+func StopK8sAuthRenewal() {
+	k8sAuthMutex.Lock()
+	defer k8sAuthMutex.Unlock()
+	for loginKey, login := range k8sAuthLogins {
+		// A login in progress does not start renewing once removed
+		select {
+		case <-login.done:
+			if login.watcher != nil {
+				login.watcher.Stop()
+			}
+		default:
+		}
+		delete(k8sAuthLogins, loginKey)
+	}
+}
@@ -7,9 +7,14 @@
 package baoConfig
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -34,6 +39,11 @@ type KeyShards struct {
 }
 
 type MonitorConfig struct {
+	// The version of the config file format: v1 or v2.
+	// The config is written in the same version that was read. Config files
+	// without an apiVersion are in the deprecated v1 format.
+	APIVersion string `yaml:"apiVersion,omitempty"`
+
 	// A map value listing all DNS names
 	// Key: Domain name
 	// Value: ServerAddress. consisting of host address and port number
@@ -49,6 +59,19 @@ type MonitorConfig struct {
 	// Value: The shard key and the base64 encoded version of that key
 	UnsealKeyShards map[string]KeyShards `yaml:"UnsealKeyShards"`
 
+	// A map value assigning servers to a cluster
+	// Key: DNS name listed under ServerAddresses
+	// Value: cluster name
+	// Servers that are not listed belong to the default cluster.
+	ServerClusters map[string]string `yaml:"ServerClusters"`
+
+	// A map value assigning unseal key shards to a cluster or server
+	// Key: shard name listed under UnsealKeyShards
+	// Value: cluster name, or DNS name of a server in the cluster
+	// Shards that are not listed belong to the server in their name when
+	// following the key-shard-<dnshost>-N format, or to the default cluster.
+	ShardOwners map[string]string `yaml:"ShardOwners"`
+
 	// A string of path to the PEM-encoded CA cert file to use to verify
 	// The server's SSL certificate
 	// Leave this empty if using the default CA cert file location
@@ -75,8 +98,28 @@ type MonitorConfig struct {
 	// Time, in seconds, the client will wait for each request before
 	// returning timeout exceeded error.
 	// Set this value in negative to use the default value of 60 seconds.
+	// The run command gives the check of a server this time for each of its
+	// requests: the health check, the seal status and the unseal calls.
 	Timeout int `yaml:"Timeout"`
 
+	// The maximum number of servers the run command checks at the same time.
+	// If this is unset or set to 0, the default of 5 will be used.
+	MaxConcurrency int `yaml:"MaxConcurrency"`
+
+	// Address the run command listens on to serve Prometheus metrics on /metrics.
+	// Example: ":9090". Leave this empty to disable the metrics.
+	MetricsAddress string `yaml:"MetricsAddress"`
+
+	// Address the run command listens on to serve the /healthz and /readyz probes.
+	// This can be the same as MetricsAddress. Leave this empty to disable the probes.
+	ProbeAddress string `yaml:"ProbeAddress"`
+
+	// Number of wait intervals the run command can go without completing a
+	// check before /healthz fails. The time allowed for checking a server is
+	// added to this time.
+	// If this is unset or set to 0, the default of 3 will be used.
+	LivenessIntervals int `yaml:"LivenessIntervals"`
+
 	// Namespace used for the k8s application.
 	Namespace string `yaml:"Namespace"`
 
@@ -87,8 +130,27 @@ type MonitorConfig struct {
 	DefaultPort int `yaml:"DefaultPort"`
 
 	// Prefix string used to find all server pods
+	// The server pods are named <PodPrefix>-<ordinal>.
+	// This is only used if none of PodLabelSelector, StatefulSetName or
+	// HeadlessServiceName are set.
 	PodPrefix string `yaml:"PodPrefix"`
 
+	// Label selector used to find all server pods
+	// Example: "app.kubernetes.io/name=openbao"
+	PodLabelSelector string `yaml:"PodLabelSelector"`
+
+	// Name of the StatefulSet that owns all server pods
+	StatefulSetName string `yaml:"StatefulSetName"`
+
+	// Name of the headless Service of the server pods.
+	// The server pods are found from the EndpointSlices of the service.
+	HeadlessServiceName string `yaml:"HeadlessServiceName"`
+
+	// Set this to true for the run command to watch the server pods for
+	// changes, instead of listing all pods on every check.
+	// Only used when the configs are pulled from kubernetes.
+	WatchPods bool `yaml:"WatchPods"`
+
 	// Suffix string for all generated pod addresses
 	// Default is "pod.cluster.local"
 	PodAddressSuffix string `yaml:"PodAddressSuffix"`
@@ -96,21 +158,175 @@ type MonitorConfig struct {
 	// Prefix string used to find root token and unseal key shards
 	// Default is "cluster-key"
 	SecretPrefix string `yaml:"SecretPrefix"`
+
+	// Time, in seconds, a raft peer can be missing from ServerAddresses before
+	// the run command removes it from the raft. A peer that autopilot reports
+	// as healthy is not removed.
+	// If this is unset or set to 0, dead raft peers are not removed.
+	RaftPeerGracePeriod int `yaml:"RaftPeerGracePeriod"`
+
+	// Set this to true to only log the raft peers the run command would remove.
+	RaftPruneDryRun bool `yaml:"RaftPruneDryRun"`
+
+	// Set this to true for the run command to elect a leader between several
+	// monitors. Only the leader unseals the servers and writes the config.
+	// A kubernetes Lease is used when the configs are pulled from kubernetes,
+	// otherwise a lock file on the host is used.
+	LeaderElection bool `yaml:"LeaderElection"`
+
+	// Name of the kubernetes Lease used for leader election.
+	// Default is "baomon-leader"
+	LeaseName string `yaml:"LeaseName"`
+
+	// Time, in seconds, a leader holds the Lease without renewing it.
+	// If this is unset or set to 0, the default of 15 seconds will be used.
+	LeaseDuration int `yaml:"LeaseDuration"`
+
+	// Path of the lock file used for leader election on the host.
+	// Default is the config file path with the ".leader" suffix.
+	LeaderLockFile string `yaml:"LeaderLockFile"`
+
+	// Set by the bootstrap command once the root token has been revoked.
+	// The monitor then authenticates with the manager token, or with the
+	// kubernetes auth role.
+	RootTokenRevoked bool `yaml:"RootTokenRevoked"`
+
+	// Path where the kubernetes auth method is mounted.
+	// Default is "kubernetes"
+	K8sAuthMount string `yaml:"K8sAuthMount"`
+
+	// Name of the kubernetes auth role the monitor logs in with.
+	// When set, each client is authenticated with a token from the
+	// kubernetes auth method, which is renewed until its max TTL.
+	// Leave this empty to authenticate with a token from Tokens.
+	K8sAuthRole string `yaml:"K8sAuthRole"`
+
+	// Path of the projected service account token used to log in with the
+	// kubernetes auth method.
+	// Default is "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	K8sAuthTokenPath string `yaml:"K8sAuthTokenPath"`
+
+	// Where the authenticated clients get their token from. The requests
+	// that work without a token, such as health and unseal, are sent
+	// without one.
+	// Available sources: tokens, env, file and kubernetes
+	// "tokens" uses the entry TokenName from Tokens, "env" uses the
+	// environment variable TokenEnv, "file" reads the file TokenFile, and
+	// "kubernetes" logs in with the kubernetes auth role K8sAuthRole.
+	// If this is unset, the manager token is used if present, then the
+	// kubernetes auth role if set, then the root token.
+	TokenSource string `yaml:"TokenSource"`
+
+	// Release id of the token in Tokens used with the "tokens" source.
+	// Default is the manager token if present, otherwise the root token.
+	TokenName string `yaml:"TokenName"`
+
+	// Name of the environment variable used with the "env" source.
+	// Default is "BAO_TOKEN"
+	TokenEnv string `yaml:"TokenEnv"`
+
+	// Path of the token file used with the "file" source.
+	TokenFile string `yaml:"TokenFile"`
+
+	// Directory the run command writes the scheduled raft snapshots to.
+	// This can be the mount path of a PVC.
+	SnapshotDirectory string `yaml:"SnapshotDirectory"`
+
+	// Time, in seconds, between the scheduled raft snapshots of the run command.
+	// If this is unset or set to 0, no scheduled snapshots are taken.
+	SnapshotInterval int `yaml:"SnapshotInterval"`
+
+	// Number of scheduled snapshots kept in SnapshotDirectory.
+	// If this is unset or set to 0, the snapshots are not limited by count.
+	SnapshotRetentionCount int `yaml:"SnapshotRetentionCount"`
+
+	// Time, in seconds, the scheduled snapshots are kept in SnapshotDirectory.
+	// If this is unset or set to 0, the snapshots are not limited by age.
+	SnapshotRetentionAge int `yaml:"SnapshotRetentionAge"`
+
+	// Path of a file holding a 32 byte key, raw or base64 encoded.
+	// When set, Tokens and UnsealKeyShards are written encrypted under
+	// EncryptedSecrets with AES-256-GCM.
+	EncryptionKeyFile string `yaml:"EncryptionKeyFile"`
+
+	// Name of an environment variable holding a passphrase. When set, Tokens
+	// and UnsealKeyShards are written encrypted under EncryptedSecrets with
+	// AES-256-GCM, using a key derived from the passphrase with scrypt.
+	EncryptionPassphraseEnv string `yaml:"EncryptionPassphraseEnv"`
+
+	// Number of previous versions of the config file kept when it is written.
+	// The backups are named <config file>.1 to <config file>.<ConfigBackups>,
+	// newest first. If this is unset or set to 0, no backups are kept.
+	ConfigBackups int `yaml:"ConfigBackups"`
+
+	// The encrypted Tokens and UnsealKeyShards. This is decrypted when the
+	// config is read, and written when encryption is enabled.
+	EncryptedSecrets *EncryptedSecrets `yaml:"EncryptedSecrets,omitempty"`
+
+	// The source of the value of each field, by YAML key
+	sources map[string]string
+
+	// The fields overridden by environment variables or the command line,
+	// by YAML key
+	overrides map[string]*override
+
+	// Whether the Tokens and UnsealKeyShards were read encrypted
+	readEncrypted bool
 }
 
+// The release id of the token created for the monitor by the bootstrap command
+const ManagerTokenID string = "manager_token"
+
+// Read the monitor config from YAML, and validate it. All the problems found
+// are returned together as ConfigErrors, with the lines of the YAML they are
+// on. Unknown keys are reported as problems.
 func (configInstance *MonitorConfig) ReadYAMLMonitorConfig(in io.Reader) error {
+	return configInstance.ReadYAMLMonitorConfigWithOverrides(in, nil)
+}
+
+// Read the monitor config from YAML like ReadYAMLMonitorConfig, and apply
+// overrides over the values of the YAML before validating it. The overrides
+// are not written back by WriteYAMLMonitorConfig.
+func (configInstance *MonitorConfig) ReadYAMLMonitorConfigWithOverrides(in io.Reader, overrides map[string]ConfigOverride) error {
 	data, err := io.ReadAll(in)
 	if err != nil {
 		return fmt.Errorf(
 			"unable to read Host DNS config data from input. Error message: %v", err)
 	}
 
-	err = yaml.Unmarshal(data, configInstance)
+	checker, root, err := newConfigChecker(data)
 	if err != nil {
 		return fmt.Errorf(
 			"unable to unmarshal Host DNS config YAML data. Error message: %v", err)
 	}
 
+	// Unmarshal the config file in its version, and report the keys that
+	// do not belong to the config
+	if !configInstance.unmarshalVersion(data, checker, root) {
+		return checker.err()
+	}
+
+	// Apply the overrides over the file
+	fileKeys := make(map[string]bool)
+	for key := range checker.keyLines {
+		fileKeys[key] = true
+	}
+	checker.add("", configInstance.applyOverrides(fileKeys, overrides))
+	checker.overridden = make(map[string]bool)
+	for key := range configInstance.overrides {
+		checker.overridden[key] = true
+	}
+
+	// Validate YAML input for the encryption configs
+	encryptionErrors := configInstance.validateEncryptionConfig()
+	checker.addErrors(encryptionErrors)
+
+	// Decrypt the Tokens and UnsealKeyShards if they were written encrypted
+	configInstance.readEncrypted = configInstance.EncryptedSecrets != nil
+	if len(encryptionErrors) == 0 && configInstance.EncryptedSecrets != nil {
+		checker.add("EncryptedSecrets", configInstance.decryptSecrets())
+	}
+
 	// Use default port value of 8200, if no default port was specified.
 	if configInstance.DefaultPort == 0 {
 		configInstance.DefaultPort = 8200
@@ -124,53 +340,277 @@ func (configInstance *MonitorConfig) ReadYAMLMonitorConfig(in io.Reader) error {
 		}
 	}
 
+	checker.add("", configInstance.recordOverrides())
+
 	// Validate YAML input for ServerAddresses
-	err = configInstance.validateDNS()
+	checker.addErrors(configInstance.validateDNS())
+
+	// Validate YAML input for Tokens
+	checker.addErrors(configInstance.validateTokens())
+
+	// Validate YAML input for unseal key shards
+	checker.addErrors(configInstance.validateKeyShards())
+
+	// Validate YAML input for shard owners
+	checker.addErrors(configInstance.validateShardOwners())
+
+	// Validate YAML input for CACert
+	checker.addErrors(configInstance.validateCACert())
+
+	// Validate YAML input for the client certificate and key
+	checker.addErrors(configInstance.validateClientCert())
+
+	// Validate YAML input for log configs
+	checker.addErrors(configInstance.validateLogConfig())
+
+	// Validate YAML input for raft configs
+	checker.addErrors(configInstance.validateRaftConfig())
+
+	// Validate YAML input for pod discovery configs
+	checker.addErrors(configInstance.validatePodDiscovery())
+
+	// Validate YAML input for the snapshot configs
+	checker.addErrors(configInstance.validateSnapshotConfig())
+
+	// Validate YAML input for the token source
+	checker.addErrors(configInstance.validateTokenSource())
+
+	// Validate YAML input for the kubernetes auth method
+	checker.addErrors(configInstance.validateK8sAuth())
+
+	// Validate YAML input for the config backups
+	checker.addErrors(configInstance.validateConfigBackups())
+
+	// Validate YAML input for the wait interval and the timeout
+	checker.addErrors(configInstance.validateWaitInterval())
+	checker.addErrors(configInstance.validateTimeout())
+
+	// Validate YAML input for run configs
+	checker.addErrors(configInstance.validateRunConfig())
+
+	return checker.err()
+}
+
+// Write the monitor config as YAML. With encryption enabled, the Tokens and
+// UnsealKeyShards are written encrypted under EncryptedSecrets instead, which
+// also migrates a plaintext config. The values of the config file are
+// written in place of the overrides from the environment or the command line.
+func (configInstance MonitorConfig) WriteYAMLMonitorConfig(out io.Writer) error {
+	configInstance.restoreFileValues()
+	if configInstance.encryptionEnabled() {
+		encrypted, err := configInstance.encryptSecrets()
+		if err != nil {
+			return fmt.Errorf("unable to encrypt the Tokens and UnsealKeyShards: %v", err)
+		}
+		configInstance.EncryptedSecrets = encrypted
+		configInstance.Tokens = nil
+		configInstance.UnsealKeyShards = nil
+	}
+
+	data, err := configInstance.marshalYAML()
 	if err != nil {
 		return err
 	}
 
-	// Validate YAML input for Tokens
-	err = configInstance.validateTokens()
+	_, err = out.Write(data)
 	if err != nil {
-		return err
+		return fmt.Errorf(
+			"unable to write marshaled Host DNS config YAML data. Error message: %v", err)
 	}
 
-	// Validate YAML input for unseal key shards
-	err = configInstance.validateKeyShards()
+	return nil
+}
+
+// Marshal the monitor config as YAML, in the format it was read in
+func (configInstance MonitorConfig) marshalYAML() ([]byte, error) {
+	var data []byte
+	var err error
+	if configInstance.APIVersion == APIVersionV2 {
+		data, err = yaml.Marshal(configInstance.toV2())
+	} else {
+		data, err = yaml.Marshal(configInstance)
+	}
 	if err != nil {
-		return err
+		return nil, fmt.Errorf(
+			"unable to marshal Host DNS config data to YAML. Error message: %v", err)
 	}
+	return data, nil
+}
 
-	// Validate YAML input for CACert
-	err = configInstance.validateCACert()
+// Compare the monitor config with the content of the config file.
+// unchanged is true when writing the config would leave the settings and
+// the secrets of the config file as they are. The Tokens and UnsealKeyShards
+// are compared decrypted, since they are encrypted with a new nonce on each
+// write. encrypting is true when the config file holds Tokens or
+// UnsealKeyShards in plaintext, and the config is written encrypted.
+func (configInstance MonitorConfig) CompareConfigFile(current []byte) (unchanged bool, encrypting bool) {
+	var currentConfig MonitorConfig
+	err := currentConfig.ReadYAMLMonitorConfig(bytes.NewReader(current))
+	plaintext := !currentConfig.readEncrypted &&
+		(len(currentConfig.Tokens) > 0 || len(currentConfig.UnsealKeyShards) > 0)
+	encrypting = plaintext && configInstance.encryptionEnabled()
+	if err != nil || currentConfig.readEncrypted != configInstance.encryptionEnabled() {
+		return false, encrypting
+	}
+
+	configInstance.restoreFileValues()
+	currentConfig.restoreFileValues()
+	data, err := configInstance.marshalYAML()
 	if err != nil {
-		return err
+		return false, encrypting
 	}
+	currentData, err := currentConfig.marshalYAML()
+	if err != nil {
+		return false, encrypting
+	}
+	return bytes.Equal(data, currentData), encrypting
+}
 
-	// Validate YAML input for log configs
-	err = configInstance.validateLogConfig()
+// Get the API address of the server listed under ServerAddresses
+func (configInstance MonitorConfig) GetAPIAddress(dnshost string) (string, error) {
+	// Check if there is a domain name listed under ServerAddresses
+	dnsAddr, ok := configInstance.ServerAddresses[dnshost]
+	if !ok {
+		return "", fmt.Errorf("unable to find %v under the list of available DNS names", dnshost)
+	}
+
+	return strings.Join([]string{"https://", dnsAddr.Host, ":", strconv.Itoa(dnsAddr.Port)}, ""), nil
+}
+
+// Get the root token listed under Tokens.
+// The root token is the token with the duration of 0.
+func (configInstance MonitorConfig) GetRootToken() (string, error) {
+	for _, token := range configInstance.Tokens {
+		if token.Duration == 0 {
+			return token.Key, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to find a root token under Tokens")
+}
+
+// Get the manager token created by the bootstrap command
+func (configInstance MonitorConfig) GetManagerToken() (string, error) {
+	token, ok := configInstance.Tokens[ManagerTokenID]
+	if !ok {
+		return "", fmt.Errorf("unable to find the %v under Tokens", ManagerTokenID)
+	}
+
+	return token.Key, nil
+}
+
+// Record that the root token was revoked, and remove it from Tokens
+func (configInstance *MonitorConfig) RemoveRootToken() {
+	for tokenID, token := range configInstance.Tokens {
+		if token.Duration == 0 {
+			delete(configInstance.Tokens, tokenID)
+		}
+	}
+	configInstance.RootTokenRevoked = true
+}
+
+// Replace the root token, or add it as root_token if there is none.
+// The new token must pass the token format checks.
+func (configInstance *MonitorConfig) SetRootToken(rootToken string) error {
+	releaseID := "root_token"
+	for tokenID, token := range configInstance.Tokens {
+		if token.Duration == 0 {
+			releaseID = tokenID
+		}
+	}
+
+	newTokens := make(map[string]Token, len(configInstance.Tokens)+1)
+	for tokenID, token := range configInstance.Tokens {
+		newTokens[tokenID] = token
+	}
+	newTokens[releaseID] = Token{Duration: 0, Key: rootToken}
+
+	newConfig := MonitorConfig{Tokens: newTokens}
+	err := newConfig.validateTokens().asError()
 	if err != nil {
 		return err
 	}
+	configInstance.Tokens = newTokens
+	configInstance.RootTokenRevoked = false
 
 	return nil
 }
 
-func (configInstance MonitorConfig) WriteYAMLMonitorConfig(out io.Writer) error {
-	data, err := yaml.Marshal(configInstance)
-	if err != nil {
-		return fmt.Errorf(
-			"unable to marshal Host DNS config data to YAML. Error message: %v", err)
+// Get the cluster of a server or cluster name.
+// An empty string is returned for the default cluster.
+func (configInstance MonitorConfig) getCluster(name string) string {
+	if cluster, ok := configInstance.ServerClusters[name]; ok {
+		return cluster
+	}
+	for _, cluster := range configInstance.ServerClusters {
+		if cluster == name {
+			return cluster
+		}
 	}
 
-	_, err = out.Write(data)
+	return ""
+}
+
+// Shards stored from the init response are named key-shard-<dnshost>-N
+var initShardName = regexp.MustCompile(`^key-shard-(.+)-\d+$`)
+
+// The trailing index of a shard name
+var shardIndex = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// Get the cluster or server the unseal key shard belongs to
+func (configInstance MonitorConfig) getShardOwner(shardName string) string {
+	if owner, ok := configInstance.ShardOwners[shardName]; ok {
+		return owner
+	}
+	match := initShardName.FindStringSubmatch(shardName)
+	if match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+// Split the trailing index from a shard name, for ordering the shards
+func splitShardIndex(shardName string) (string, int) {
+	match := shardIndex.FindStringSubmatch(shardName)
+	if match == nil {
+		return shardName, -1
+	}
+	index, err := strconv.Atoi(match[2])
 	if err != nil {
-		return fmt.Errorf(
-			"unable to write marshaled Host DNS config YAML data. Error message: %v", err)
+		return shardName, -1
 	}
 
-	return nil
+	return match[1], index
+}
+
+// Get the names of the non-recovery unseal key shards that belong to the
+// cluster of dnshost, ordered by the shard index.
+func (configInstance MonitorConfig) GetUnsealShards(dnshost string) []string {
+	cluster := configInstance.getCluster(dnshost)
+
+	var shardNames []string
+	for shardName := range configInstance.UnsealKeyShards {
+		// Don't use recovery keys
+		if strings.Contains(shardName, "recovery") {
+			continue
+		}
+		owner := configInstance.getShardOwner(shardName)
+		if owner == dnshost || configInstance.getCluster(owner) == cluster {
+			shardNames = append(shardNames, shardName)
+		}
+	}
+
+	slices.SortFunc(shardNames, func(a, b string) int {
+		prefixA, indexA := splitShardIndex(a)
+		prefixB, indexB := splitShardIndex(b)
+		if prefixA != prefixB {
+			return strings.Compare(prefixA, prefixB)
+		}
+		return cmp.Compare(indexA, indexB)
+	})
+
+	return shardNames
 }
 
 // Create a new config based on the monitor config
@@ -184,14 +624,12 @@ func (configInstance MonitorConfig) NewConfig(dnshost string) (*clientapi.Config
 	}
 	slog.Debug("No issues found in retrieving default config.")
 
-	// Check if there is a domain name listed under ServerAddresses
-	dnsAddr, ok := configInstance.ServerAddresses[dnshost]
-	if !ok {
-		return defConfig, fmt.Errorf("unable to find %v under the list of available DNS names", dnshost)
-	}
-
 	// Set the DNS address as the configured address for the server
-	defConfig.Address = strings.Join([]string{"https://", dnsAddr.Host, ":", strconv.Itoa(dnsAddr.Port)}, "")
+	apiAddr, err := configInstance.GetAPIAddress(dnshost)
+	if err != nil {
+		return defConfig, err
+	}
+	defConfig.Address = apiAddr
 
 	slog.Debug(fmt.Sprintf("Server address set to %v", defConfig.Address))
 
@@ -207,7 +645,7 @@ func (configInstance MonitorConfig) NewConfig(dnshost string) (*clientapi.Config
 	newTLSconfig.ClientKey = configInstance.ClientKey
 
 	// This does nothing if newTLSconfig is empty
-	err := defConfig.ConfigureTLS(&newTLSconfig)
+	err = defConfig.ConfigureTLS(&newTLSconfig)
 	if err != nil {
 		return defConfig, fmt.Errorf("error with configuring TLS: %v", err)
 	}
@@ -224,21 +662,58 @@ func (configInstance MonitorConfig) NewConfig(dnshost string) (*clientapi.Config
 	return defConfig, nil
 }
 
+// Create an api client for dnshost without a token, for the requests that
+// work without one, such as health, seal status, unseal, init, rekey and
+// generate-root. No token is sent to the servers with these requests.
 func (configInstance MonitorConfig) SetupClient(dnshost string) (*clientapi.Client, error) {
+	newClient, _, err := configInstance.setupClient(dnshost)
+	if err != nil {
+		return nil, err
+	}
+	// Don't use the token from the environment either
+	newClient.ClearToken()
+
+	slog.Debug("Client setup complete.")
+	return newClient, nil
+}
+
+// Create an api client for dnshost that is authenticated with the token from
+// the token source of the monitor config. Fails if no token is available.
+func (configInstance MonitorConfig) SetupAuthClient(dnshost string) (*clientapi.Client, error) {
+	return configInstance.SetupAuthClientWithContext(context.Background(), dnshost)
+}
+
+// Create an authenticated api client for dnshost. Getting the token, which
+// may need a login, must complete before ctx is done.
+func (configInstance MonitorConfig) SetupAuthClientWithContext(ctx context.Context, dnshost string) (*clientapi.Client, error) {
+	newClient, newConfig, err := configInstance.setupClient(dnshost)
+	if err != nil {
+		return nil, err
+	}
+	token, err := configInstance.GetClientToken(ctx, dnshost, newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get a token for host %v: %v", dnshost, err)
+	}
+	newClient.SetToken(token)
+
+	slog.Debug("Client setup complete.")
+	return newClient, nil
+}
+
+func (configInstance MonitorConfig) setupClient(dnshost string) (*clientapi.Client, *clientapi.Config, error) {
 	slog.Debug(fmt.Sprintf("Setting up client for host %v", dnshost))
 	newConfig, err := configInstance.NewConfig(dnshost)
 	if err != nil {
-		return nil, fmt.Errorf("error in creating new config: %v", err)
+		return nil, nil, fmt.Errorf("error in creating new config: %v", err)
 	}
 
 	slog.Debug("Creating client for API access...")
 	newClient, err := clientapi.NewClient(newConfig)
 	if err != nil {
-		return nil, fmt.Errorf("error in creating new client: %v", err)
+		return nil, nil, fmt.Errorf("error in creating new client: %v", err)
 	}
 
-	slog.Debug("Client setup complete.")
-	return newClient, nil
+	return newClient, newConfig, nil
 }
 
 // Parse the new keys from the init responce into the monitor config
@@ -274,6 +749,10 @@ func (configInstance *MonitorConfig) ParseInitResponse(dnshost string, responce
 			Key:       responce.Keys[i],
 			KeyBase64: responce.KeysB64[i],
 		}
+		if configInstance.ShardOwners == nil {
+			configInstance.ShardOwners = make(map[string]string)
+		}
+		configInstance.ShardOwners[keyShardName] = dnshost
 	}
 
 	slog.Debug("Parsing the recovery key shards...")
@@ -295,3 +774,35 @@ func (configInstance *MonitorConfig) ParseInitResponse(dnshost string, responce
 	slog.Debug("Parsing init response complete")
 	return nil
 }
+
+// Replace the unseal key shards of the cluster of dnshost with the new keys
+// from a rekey. The recovery key shards are kept.
+func (configInstance *MonitorConfig) ReplaceUnsealShards(dnshost string, keys []string, keysB64 []string) error {
+	slog.Debug(fmt.Sprintf("Replacing the unseal key shards of host %v", dnshost))
+	if len(keys) != len(keysB64) {
+		return fmt.Errorf("the rekey returned %v keys but %v base64 keys", len(keys), len(keysB64))
+	}
+
+	for _, shardName := range configInstance.GetUnsealShards(dnshost) {
+		delete(configInstance.UnsealKeyShards, shardName)
+		delete(configInstance.ShardOwners, shardName)
+	}
+
+	keyShardheader := strings.Join([]string{"key", "shard", dnshost}, "-")
+	if configInstance.UnsealKeyShards == nil {
+		configInstance.UnsealKeyShards = make(map[string]KeyShards)
+	}
+	if configInstance.ShardOwners == nil {
+		configInstance.ShardOwners = make(map[string]string)
+	}
+	for i := range len(keys) {
+		keyShardName := strings.Join([]string{keyShardheader, strconv.Itoa(i)}, "-")
+		configInstance.UnsealKeyShards[keyShardName] = KeyShards{
+			Key:       keys[i],
+			KeyBase64: keysB64[i],
+		}
+		configInstance.ShardOwners[keyShardName] = dnshost
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoConfig
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// The state of a server pod tracked by the pod watcher
+type podState struct {
+	Address ServerAddress
+	Ready   bool
+}
+
+// PodWatcher keeps an up-to-date list of server addresses from a watch on
+// the server pods, or on the EndpointSlices of the headless service,
+// instead of listing them on every run cycle.
+type PodWatcher struct {
+	mutex   sync.Mutex
+	pods    map[string]podState
+	changed chan struct{}
+	stopCh  chan struct{}
+	factory informers.SharedInformerFactory
+
+	// The pod settings of the config the watcher was created from
+	settings podSettings
+
+	// Get the current server pods from the watch cache
+	listPods func() (map[string]podState, error)
+}
+
+// Create a pod watcher for the server pods of the monitor config
+func (configInstance *MonitorConfig) NewPodWatcher(config *rest.Config) (*PodWatcher, error) {
+	slog.Debug("Setting up the server pod watcher")
+	settings := configInstance.getPodSettings()
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := &PodWatcher{
+		pods:     make(map[string]podState),
+		changed:  make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+		settings: settings,
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { watcher.refresh() },
+		UpdateFunc: func(oldObj, newObj interface{}) { watcher.refresh() },
+		DeleteFunc: func(obj interface{}) { watcher.refresh() },
+	}
+
+	var informer cache.SharedIndexInformer
+	if settings.headlessServiceName != "" {
+		// Watch the EndpointSlices of the headless service
+		selector := settings.endpointSliceSelector()
+		watcher.factory = informers.NewSharedInformerFactoryWithOptions(
+			clientset, 0, informers.WithNamespace(settings.namespace),
+			informers.WithTweakListOptions(func(options *metaV1.ListOptions) {
+				options.LabelSelector = selector
+			}))
+		sliceInformer := watcher.factory.Discovery().V1().EndpointSlices()
+		sliceLister := sliceInformer.Lister()
+		informer = sliceInformer.Informer()
+		watcher.listPods = func() (map[string]podState, error) {
+			endpointSlices, err := sliceLister.EndpointSlices(settings.namespace).List(labels.Everything())
+			if err != nil {
+				return nil, err
+			}
+			return settings.endpointSlicePods(endpointSlices), nil
+		}
+	} else {
+		// Watch the server pods
+		filter, err := settings.getPodFilter(context.Background(), clientset)
+		if err != nil {
+			return nil, err
+		}
+		watcher.factory = informers.NewSharedInformerFactoryWithOptions(
+			clientset, 0, informers.WithNamespace(settings.namespace),
+			informers.WithTweakListOptions(func(options *metaV1.ListOptions) {
+				options.LabelSelector = filter.labelSelector()
+			}))
+		podInformer := watcher.factory.Core().V1().Pods()
+		podLister := podInformer.Lister()
+		informer = podInformer.Informer()
+		watcher.listPods = func() (map[string]podState, error) {
+			pods, err := podLister.Pods(settings.namespace).List(labels.Everything())
+			if err != nil {
+				return nil, err
+			}
+			states := make(map[string]podState)
+			for _, pod := range pods {
+				// Skip pods that have no address yet
+				if filter.matches(pod) && pod.Status.PodIP != "" {
+					states[pod.ObjectMeta.Name] = podState{
+						Address: settings.serverAddress(pod.Status.PodIP),
+						Ready:   podReady(pod),
+					}
+				}
+			}
+			return states, nil
+		}
+	}
+
+	_, err = informer.AddEventHandler(handler)
+	if err != nil {
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// Start watching the server pods. Returns after the initial list of pods
+// has been received.
+func (watcher *PodWatcher) Start() error {
+	slog.Debug("Starting the server pod watcher...")
+	watcher.factory.Start(watcher.stopCh)
+	for informerType, synced := range watcher.factory.WaitForCacheSync(watcher.stopCh) {
+		if !synced {
+			return fmt.Errorf("unable to sync the watch for %v", informerType)
+		}
+	}
+	watcher.refresh()
+	slog.Debug("Server pod watcher started.")
+
+	return nil
+}
+
+// Stop watching the server pods
+func (watcher *PodWatcher) Stop() {
+	close(watcher.stopCh)
+	watcher.factory.Shutdown()
+}
+
+// Check if the pod settings of the config differ from the settings the
+// watcher was created with. The watcher must then be replaced.
+func (watcher *PodWatcher) SettingsChanged(configInstance *MonitorConfig) bool {
+	return watcher.settings != configInstance.getPodSettings()
+}
+
+// A channel that receives a value when a server pod was added, removed,
+// got a new address or changed its readiness.
+func (watcher *PodWatcher) Changed() <-chan struct{} {
+	return watcher.changed
+}
+
+// Get a copy of the current server addresses
+func (watcher *PodWatcher) ServerAddresses() map[string]ServerAddress {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	addresses := make(map[string]ServerAddress, len(watcher.pods))
+	for podName, state := range watcher.pods {
+		addresses[podName] = state.Address
+	}
+	return addresses
+}
+
+// Check if the pod has the Ready condition
+func podReady(pod *coreV1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == coreV1.PodReady {
+			return condition.Status == coreV1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// Rebuild the server pod states from the watch cache, and notify if changed
+func (watcher *PodWatcher) refresh() {
+	newPods, err := watcher.listPods()
+	if err != nil {
+		slog.Error(fmt.Sprintf("unable to list the watched server pods: %v", err))
+		return
+	}
+
+	watcher.mutex.Lock()
+	changed := !maps.Equal(watcher.pods, newPods)
+	watcher.pods = newPods
+	watcher.mutex.Unlock()
+
+	if changed {
+		slog.Debug(fmt.Sprintf("Server pods changed: %v", newPods))
+		select {
+		case watcher.changed <- struct{}{}:
+		default:
+			// A notification is already pending
+		}
+	}
+}
@@ -0,0 +1,220 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoConfig
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Algorithms of the encrypted secrets
+var encryptionCipher string = "aes-256-gcm"
+var kdfNone string = "none"
+var kdfScrypt string = "scrypt"
+
+// Parameters of the key derivation from a passphrase
+var scryptN int = 32768
+var scryptR int = 8
+var scryptP int = 1
+var encryptionKeySize int = 32
+var saltSize int = 16
+
+// The Tokens and UnsealKeyShards sections, encrypted with AES-GCM.
+// The values are base64 encoded.
+type EncryptedSecrets struct {
+	Cipher string `yaml:"cipher"`
+	KDF    string `yaml:"kdf"`
+	Salt   string `yaml:"salt,omitempty"`
+	Nonce  string `yaml:"nonce"`
+	Data   string `yaml:"data"`
+}
+
+// The sections of the monitor config that are encrypted
+type secretSections struct {
+	Tokens          map[string]Token     `yaml:"Tokens"`
+	UnsealKeyShards map[string]KeyShards `yaml:"UnsealKeyShards"`
+}
+
+// Check if the monitor config has an encryption key or passphrase
+func (configInstance MonitorConfig) encryptionEnabled() bool {
+	return configInstance.EncryptionKeyFile != "" || configInstance.EncryptionPassphraseEnv != ""
+}
+
+// Get the encryption key from the key file, or derive it from the passphrase
+// with the salt. The key file holds 32 bytes, either raw or base64 encoded.
+func (configInstance MonitorConfig) getEncryptionKey(kdf string, salt []byte) ([]byte, error) {
+	switch kdf {
+	case kdfNone:
+		if configInstance.EncryptionKeyFile == "" {
+			return nil, fmt.Errorf("the secrets were encrypted with a key file, but EncryptionKeyFile is not set")
+		}
+		data, err := os.ReadFile(configInstance.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the encryption key file: %v", err)
+		}
+		if len(data) == encryptionKeySize {
+			return data, nil
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("the encryption key file %v must hold %v bytes, raw or base64 encoded",
+				configInstance.EncryptionKeyFile, encryptionKeySize)
+		}
+		return key, nil
+	case kdfScrypt:
+		if configInstance.EncryptionPassphraseEnv == "" {
+			return nil, fmt.Errorf("the secrets were encrypted with a passphrase, but EncryptionPassphraseEnv is not set")
+		}
+		passphrase := os.Getenv(configInstance.EncryptionPassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("the environment variable %v is not set", configInstance.EncryptionPassphraseEnv)
+		}
+		key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, encryptionKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to derive the encryption key: %v", err)
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown key derivation %v", kdf)
+}
+
+// Encrypt the Tokens and UnsealKeyShards sections
+func (configInstance MonitorConfig) encryptSecrets() (*EncryptedSecrets, error) {
+	slog.Debug("Encrypting the Tokens and UnsealKeyShards")
+	encrypted := &EncryptedSecrets{Cipher: encryptionCipher, KDF: kdfNone}
+	var salt []byte
+	if configInstance.EncryptionKeyFile == "" {
+		encrypted.KDF = kdfScrypt
+		salt = make([]byte, saltSize)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, err
+		}
+		encrypted.Salt = base64.StdEncoding.EncodeToString(salt)
+	}
+	key, err := configInstance.getEncryptionKey(encrypted.KDF, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := yaml.Marshal(secretSections{
+		Tokens:          configInstance.Tokens,
+		UnsealKeyShards: configInstance.UnsealKeyShards,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	encrypted.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	encrypted.Data = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil))
+
+	return encrypted, nil
+}
+
+// Decrypt the EncryptedSecrets section into Tokens and UnsealKeyShards.
+// Entries that are also in plaintext are rejected.
+func (configInstance *MonitorConfig) decryptSecrets() error {
+	slog.Debug("Decrypting the Tokens and UnsealKeyShards")
+	encrypted := configInstance.EncryptedSecrets
+	if encrypted.Cipher != encryptionCipher {
+		return fmt.Errorf("unknown cipher %v of the EncryptedSecrets", encrypted.Cipher)
+	}
+
+	decode := func(name string, value string) ([]byte, error) {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode the %v of the EncryptedSecrets: %v", name, err)
+		}
+		return decoded, nil
+	}
+	salt, err := decode("salt", encrypted.Salt)
+	if err != nil {
+		return err
+	}
+	nonce, err := decode("nonce", encrypted.Nonce)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := decode("data", encrypted.Data)
+	if err != nil {
+		return err
+	}
+
+	key, err := configInstance.getEncryptionKey(encrypted.KDF, salt)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return fmt.Errorf("the nonce of the EncryptedSecrets has the wrong size")
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt the EncryptedSecrets. Check the encryption key: %v", err)
+	}
+
+	var sections secretSections
+	err = yaml.Unmarshal(plaintext, &sections)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal the decrypted secrets: %v", err)
+	}
+
+	// The secrets belong to the config file, even when overridden
+	tokens := configInstance.fileField("Tokens").Addr().Interface().(*map[string]Token)
+	if *tokens == nil {
+		*tokens = make(map[string]Token)
+	}
+	for tokenID, token := range sections.Tokens {
+		if _, ok := (*tokens)[tokenID]; ok {
+			return fmt.Errorf("the token %v is listed both in Tokens and in EncryptedSecrets", tokenID)
+		}
+		(*tokens)[tokenID] = token
+	}
+	shards := configInstance.fileField("UnsealKeyShards").Addr().Interface().(*map[string]KeyShards)
+	if *shards == nil {
+		*shards = make(map[string]KeyShards)
+	}
+	for shardName, shard := range sections.UnsealKeyShards {
+		if _, ok := (*shards)[shardName]; ok {
+			return fmt.Errorf("the shard %v is listed both in UnsealKeyShards and in EncryptedSecrets", shardName)
+		}
+		(*shards)[shardName] = shard
+	}
+	configInstance.EncryptedSecrets = nil
+
+	return nil
+}
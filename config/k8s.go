@@ -5,42 +5,210 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
+	clientapi "github.com/openbao/openbao/api/v2"
+	coreV1 "k8s.io/api/core/v1"
+	discoveryV1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 )
 
 // Default values in case the values are not included in the config
-var k8sNamespace string = "openbao"
-var podPort int = 8200
-var podPrefix string = "stx-openbao"
-var podAddressSuffix string = "pod.cluster.local"
-var secretPrefix string = "cluster-key"
+var defaultK8sNamespace string = "openbao"
+var defaultPodPort int = 8200
+var defaultPodPrefix string = "stx-openbao"
+var defaultPodAddressSuffix string = "pod.cluster.local"
+var defaultSecretPrefix string = "cluster-key"
+
+// Labels added to the kubernetes secrets created by the monitor
+var secretManagedByLabel string = "app.kubernetes.io/managed-by"
+var secretManagedByValue string = "baomon"
+var secretInstanceLabel string = "app.kubernetes.io/instance"
+
+// Keys of the manager secret created by the bootstrap command
+var managerTokenKey string = "strdata"
+var managerPeriodKey string = "period"
+var managerRoleKey string = "role"
+var managerMountKey string = "mount"
+var managerRootRevokedKey string = "root_token_revoked"
+
+// Suffix of the secret holding all the new key shards of a rekey while the
+// secrets of the key shards are replaced
+var rekeySecretSuffix string = "rekey"
 
 type keySecret struct {
 	Key        []string `json:"keys"`
 	KeyEncoded []string `json:"keys_base64"`
 }
 
-// Get list of DNS names fro k8s pods
-func (configInstance *MonitorConfig) MigratePodConfig(config *rest.Config) error {
-	slog.Debug("Migrating server addresses from kubernetes server pods")
-	// Use the settings from config if they aren't empty
-	if configInstance.Namespace != "" {
-		k8sNamespace = configInstance.Namespace
+// The settings for finding the server pods and their addresses.
+// The pod watcher keeps its own copy, since the config can be replaced by a
+// reload while the watcher runs.
+type podSettings struct {
+	namespace           string
+	port                int
+	prefix              string
+	addressSuffix       string
+	statefulSetName     string
+	podLabelSelector    string
+	headlessServiceName string
+}
+
+// Get the pod settings from config, using the defaults for the empty ones
+func (configInstance *MonitorConfig) getPodSettings() podSettings {
+	settings := podSettings{
+		namespace:           configInstance.GetNamespace(),
+		port:                defaultPodPort,
+		prefix:              defaultPodPrefix,
+		addressSuffix:       defaultPodAddressSuffix,
+		statefulSetName:     configInstance.StatefulSetName,
+		podLabelSelector:    configInstance.PodLabelSelector,
+		headlessServiceName: configInstance.HeadlessServiceName,
 	}
 	if configInstance.DefaultPort != 0 {
-		podPort = configInstance.DefaultPort
+		settings.port = configInstance.DefaultPort
 	}
 	if configInstance.PodPrefix != "" {
-		podPrefix = configInstance.PodPrefix
+		settings.prefix = configInstance.PodPrefix
 	}
 	if configInstance.PodAddressSuffix != "" {
-		podAddressSuffix = configInstance.PodAddressSuffix
+		settings.addressSuffix = configInstance.PodAddressSuffix
 	}
+	return settings
+}
+
+// Get the kubernetes namespace of the servers
+func (configInstance *MonitorConfig) GetNamespace() string {
+	if configInstance.Namespace != "" {
+		return configInstance.Namespace
+	}
+	return defaultK8sNamespace
+}
+
+// Get the prefix of the names of the kubernetes secrets
+func (configInstance *MonitorConfig) getSecretPrefix() string {
+	if configInstance.SecretPrefix != "" {
+		return configInstance.SecretPrefix
+	}
+	return defaultSecretPrefix
+}
+
+// Get the server address of a server pod from its IP
+func (settings podSettings) serverAddress(podIP string) ServerAddress {
+	podURL := fmt.Sprintf("%v.%v.%v", strings.ReplaceAll(podIP, ".", "-"), settings.namespace, settings.addressSuffix)
+	return ServerAddress{podURL, settings.port}
+}
+
+// Selects the server pods among the pods of the namespace
+type podFilter struct {
+	// Label selector the pods must match. Nil to match any pod.
+	selector labels.Selector
+
+	// Name of the StatefulSet that must own the pods. Empty for any owner.
+	ownerName string
+
+	// Regex the pod names must match. Nil to match any name.
+	nameRegex *regexp.Regexp
+}
+
+// Check if the pod is selected by the filter
+func (filter podFilter) matches(pod *coreV1.Pod) bool {
+	if filter.selector != nil && !filter.selector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+		return false
+	}
+	if filter.ownerName != "" {
+		owner := metaV1.GetControllerOf(pod)
+		if owner == nil || owner.Kind != "StatefulSet" || owner.Name != filter.ownerName {
+			return false
+		}
+	}
+	if filter.nameRegex != nil && !filter.nameRegex.MatchString(pod.ObjectMeta.Name) {
+		return false
+	}
+	return true
+}
+
+// The label selector string of the filter, for list options
+func (filter podFilter) labelSelector() string {
+	if filter.selector == nil {
+		return ""
+	}
+	return filter.selector.String()
+}
+
+// Get the filter for the server pods from the pod settings.
+// Server pods are found by their owning StatefulSet, by a label selector,
+// or by the pod prefix followed by the pod ordinal.
+func (settings podSettings) getPodFilter(ctx context.Context, clientset kubernetes.Interface) (podFilter, error) {
+	var filter podFilter
+	switch {
+	case settings.statefulSetName != "":
+		slog.Debug(fmt.Sprintf("Finding server pods owned by StatefulSet %v", settings.statefulSetName))
+		statefulSet, err := clientset.AppsV1().StatefulSets(settings.namespace).Get(
+			ctx, settings.statefulSetName, metaV1.GetOptions{})
+		if err != nil {
+			return filter, err
+		}
+		filter.selector, err = metaV1.LabelSelectorAsSelector(statefulSet.Spec.Selector)
+		if err != nil {
+			return filter, fmt.Errorf("invalid selector in StatefulSet %v: %v", statefulSet.Name, err)
+		}
+		filter.ownerName = statefulSet.Name
+	case settings.podLabelSelector != "":
+		slog.Debug(fmt.Sprintf("Finding server pods with label selector %v", settings.podLabelSelector))
+		selector, err := labels.Parse(settings.podLabelSelector)
+		if err != nil {
+			return filter, fmt.Errorf("invalid PodLabelSelector: %v", err)
+		}
+		filter.selector = selector
+	default:
+		slog.Debug(fmt.Sprintf("Finding server pods with prefix %v", settings.prefix))
+		filter.nameRegex = regexp.MustCompile(fmt.Sprintf("^%v-\\d+$", regexp.QuoteMeta(settings.prefix)))
+	}
+
+	return filter, nil
+}
+
+// Get the server pods from the EndpointSlices of the headless service.
+// Endpoints that are not ready are included, since sealed servers are not ready.
+func (settings podSettings) endpointSlicePods(endpointSlices []*discoveryV1.EndpointSlice) map[string]podState {
+	pods := make(map[string]podState)
+	for _, endpointSlice := range endpointSlices {
+		for _, endpoint := range endpointSlice.Endpoints {
+			if endpoint.TargetRef == nil || endpoint.TargetRef.Kind != "Pod" || len(endpoint.Addresses) == 0 {
+				continue
+			}
+			ready := endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready
+			pods[endpoint.TargetRef.Name] = podState{
+				Address: settings.serverAddress(endpoint.Addresses[0]),
+				Ready:   ready,
+			}
+		}
+	}
+	return pods
+}
+
+// The label selector for the EndpointSlices of the headless service
+func (settings podSettings) endpointSliceSelector() string {
+	return labels.SelectorFromSet(labels.Set{
+		discoveryV1.LabelServiceName: settings.headlessServiceName,
+	}).String()
+}
+
+// Get list of DNS names fro k8s pods
+func (configInstance *MonitorConfig) MigratePodConfig(config *rest.Config) error {
+	slog.Debug("Migrating server addresses from kubernetes server pods")
+	settings := configInstance.getPodSettings()
 
 	slog.Debug("Setting up kubernetes client...")
 	// create clientset
@@ -50,35 +218,58 @@ func (configInstance *MonitorConfig) MigratePodConfig(config *rest.Config) error
 	}
 	slog.Debug("Setting up kubernetes client complete")
 
-	// client for core
-	coreClient := clientset.CoreV1()
 	ctx := context.Background()
 
-	slog.Debug("Accessing the server pods for the addresses...")
-	// get pod list
-	pods, err := coreClient.Pods(k8sNamespace).List(ctx, metaV1.ListOptions{})
-	if err != nil {
-		return err
-	}
+	// The existing DNS names are kept if the discovery fails
+	addresses := make(map[string]ServerAddress)
+
+	if settings.headlessServiceName != "" {
+		slog.Debug(fmt.Sprintf("Accessing the EndpointSlices of service %v for the addresses...",
+			settings.headlessServiceName))
+		sliceList, err := clientset.DiscoveryV1().EndpointSlices(settings.namespace).List(ctx, metaV1.ListOptions{
+			LabelSelector: settings.endpointSliceSelector(),
+		})
+		if err != nil {
+			return err
+		}
+		endpointSlices := make([]*discoveryV1.EndpointSlice, 0, len(sliceList.Items))
+		for i := range sliceList.Items {
+			endpointSlices = append(endpointSlices, &sliceList.Items[i])
+		}
+		for podName, state := range settings.endpointSlicePods(endpointSlices) {
+			addresses[podName] = state.Address
+		}
+	} else {
+		filter, err := settings.getPodFilter(ctx, clientset)
+		if err != nil {
+			return err
+		}
 
-	// clear existing DNS names
-	configInstance.ServerAddresses = make(map[string]ServerAddress)
+		slog.Debug("Accessing the server pods for the addresses...")
+		// get pod list
+		pods, err := clientset.CoreV1().Pods(settings.namespace).List(ctx, metaV1.ListOptions{
+			LabelSelector: filter.labelSelector(),
+		})
+		if err != nil {
+			return err
+		}
 
-	// Use pod and its ip to fill in the "ServerAddresses" section
-	r, _ := regexp.Compile(fmt.Sprintf("%v-\\d$", podPrefix))
-	for _, pod := range pods.Items {
-		podName := pod.ObjectMeta.Name
-		if r.Match([]byte(podName)) {
-			podIP := pod.Status.PodIP
-			podURL := fmt.Sprintf("%v.%v.%v", strings.ReplaceAll(podIP, ".", "-"), k8sNamespace, podAddressSuffix)
-			configInstance.ServerAddresses[podName] = ServerAddress{podURL, podPort}
+		// Use pod and its ip to fill in the "ServerAddresses" section
+		for _, pod := range pods.Items {
+			// Skip pods that have no address yet
+			if filter.matches(&pod) && pod.Status.PodIP != "" {
+				addresses[pod.ObjectMeta.Name] = settings.serverAddress(pod.Status.PodIP)
+			}
 		}
 	}
 	slog.Debug("All addresses obtained.")
 
 	// Validate input for ServerAddresses
-	err = configInstance.validateDNS()
+	previous := configInstance.ServerAddresses
+	configInstance.ServerAddresses = addresses
+	err = configInstance.validateDNS().asError()
 	if err != nil {
+		configInstance.ServerAddresses = previous
 		return err
 	}
 
@@ -89,14 +280,7 @@ func (configInstance *MonitorConfig) MigratePodConfig(config *rest.Config) error
 // Get root token and unseal key shards from k8s secrets
 func (configInstance *MonitorConfig) MigrateSecretConfig(config *rest.Config) error {
 	slog.Debug("Migrating root-token and unseal key shards from kubernetes secrets")
-	// Use the settings from config if they aren't empty
-	if configInstance.Namespace != "" {
-		k8sNamespace = configInstance.Namespace
-	}
-	if configInstance.SecretPrefix != "" {
-		secretPrefix = configInstance.SecretPrefix
-	}
-
+	secretPrefix := configInstance.getSecretPrefix()
 	slog.Debug("Setting up kubernetes client...")
 	// create clientset
 	clientset, err := kubernetes.NewForConfig(config)
@@ -106,7 +290,7 @@ func (configInstance *MonitorConfig) MigrateSecretConfig(config *rest.Config) er
 	slog.Debug("Setting up kubernetes client complete")
 
 	// client for secret
-	secretClient := clientset.CoreV1().Secrets(k8sNamespace)
+	secretClient := clientset.CoreV1().Secrets(configInstance.GetNamespace())
 
 	ctx := context.Background()
 
@@ -122,11 +306,25 @@ func (configInstance *MonitorConfig) MigrateSecretConfig(config *rest.Config) er
 	configInstance.UnsealKeyShards = make(map[string]KeyShards)
 
 	// Use secrets to fill in the "Tokens" and "UnsealKeyShards" section
+	managerSecretName := strings.Join([]string{secretPrefix, "manager"}, "-")
+	rekeySecretName := strings.Join([]string{secretPrefix, rekeySecretSuffix}, "-")
+	var rekeyShards *keySecret = nil
 	for _, secret := range secrets.Items {
 		secretName := secret.ObjectMeta.Name
 		if strings.HasPrefix(secretName, secretPrefix) {
 			secretData := secret.Data["strdata"]
-			if strings.HasSuffix(secretName, "root") {
+			if secretName == managerSecretName {
+				// The credential of the monitor created by the bootstrap command
+				configInstance.migrateManagerSecret(secret.Data)
+			} else if secretName == rekeySecretName {
+				// All the new key shards of a rekey that did not replace
+				// all the secrets of the key shards
+				rekeyShards = &keySecret{}
+				err := json.Unmarshal(secretData, rekeyShards)
+				if err != nil {
+					return err
+				}
+			} else if strings.HasSuffix(secretName, "root") {
 				// secretData should be the root token
 				configInstance.Tokens[secretName] = Token{Duration: 0, Key: string(secretData)}
 			} else {
@@ -143,16 +341,35 @@ func (configInstance *MonitorConfig) MigrateSecretConfig(config *rest.Config) er
 			}
 		}
 	}
+	if rekeyShards != nil {
+		slog.Warn(fmt.Sprintf("The secret %v of an interrupted rekey exists. Using its unseal key shards.", rekeySecretName))
+		if len(rekeyShards.Key) != len(rekeyShards.KeyEncoded) {
+			return fmt.Errorf("the secret %v has %v keys but %v base64 keys",
+				rekeySecretName, len(rekeyShards.Key), len(rekeyShards.KeyEncoded))
+		}
+		shardSecret := shardSecretRegex(secretPrefix)
+		for shardName := range configInstance.UnsealKeyShards {
+			if shardSecret.MatchString(shardName) {
+				delete(configInstance.UnsealKeyShards, shardName)
+			}
+		}
+		for i := range rekeyShards.Key {
+			configInstance.UnsealKeyShards[strings.Join([]string{secretPrefix, strconv.Itoa(i)}, "-")] = KeyShards{
+				Key:       rekeyShards.Key[i],
+				KeyBase64: rekeyShards.KeyEncoded[i],
+			}
+		}
+	}
 	slog.Debug("Root token and unseal key shards obtained.")
 
 	// Validate input for Tokens
-	err = configInstance.validateTokens()
+	err = configInstance.validateTokens().asError()
 	if err != nil {
 		return err
 	}
 
 	// Validate input for unseal key shards
-	err = configInstance.validateKeyShards()
+	err = configInstance.validateKeyShards().asError()
 	if err != nil {
 		return err
 	}
@@ -161,6 +378,347 @@ func (configInstance *MonitorConfig) MigrateSecretConfig(config *rest.Config) er
 	return nil
 }
 
+// Fill in the manager token or kubernetes auth role from the manager secret
+func (configInstance *MonitorConfig) migrateManagerSecret(data map[string][]byte) {
+	if token := string(data[managerTokenKey]); token != "" {
+		period, _ := strconv.Atoi(string(data[managerPeriodKey]))
+		configInstance.Tokens[ManagerTokenID] = Token{Duration: period, Key: token}
+	}
+	if role := string(data[managerRoleKey]); role != "" && configInstance.K8sAuthRole == "" {
+		configInstance.K8sAuthRole = role
+		configInstance.K8sAuthMount = string(data[managerMountKey])
+	}
+	if string(data[managerRootRevokedKey]) == "true" {
+		configInstance.RootTokenRevoked = true
+	}
+}
+
+// Get the client for the k8s secrets in the namespace of the monitor config
+func (configInstance *MonitorConfig) getSecretClient(config *rest.Config) (typedCoreV1.SecretInterface, error) {
+	slog.Debug("Setting up kubernetes client...")
+	// create clientset
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Setting up kubernetes client complete")
+
+	return clientset.CoreV1().Secrets(configInstance.GetNamespace()), nil
+}
+
+// Check that no k8s secrets exist for the root token and unseal key shards.
+// This should be checked before init, so that the init response is not lost.
+func (configInstance *MonitorConfig) CheckInitSecrets(config *rest.Config) error {
+	secretClient, err := configInstance.getSecretClient(config)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := secretClient.List(context.Background(), metaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets.Items {
+		if strings.HasPrefix(secret.ObjectMeta.Name, configInstance.getSecretPrefix()) {
+			return fmt.Errorf(
+				"the secret %v already exists in namespace %v, refusing to overwrite",
+				secret.ObjectMeta.Name, configInstance.GetNamespace())
+		}
+	}
+
+	return nil
+}
+
+// Store the root token and key shards from the init response in k8s secrets.
+// One secret is created for each key shard, and one for the root token.
+// Existing secrets are never overwritten.
+func (configInstance *MonitorConfig) StoreInitSecrets(config *rest.Config, dnshost string, responce *clientapi.InitResponse) error {
+	slog.Debug("Storing the init response to kubernetes secrets")
+	secretPrefix := configInstance.getSecretPrefix()
+	secretClient, err := configInstance.getSecretClient(config)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{secretManagedByLabel: secretManagedByValue}
+	if len(validation.IsValidLabelValue(dnshost)) == 0 {
+		labels[secretInstanceLabel] = dnshost
+	}
+
+	// Collect all secrets before creating any of them
+	secretData := make(map[string][]byte)
+	secretData[strings.Join([]string{secretPrefix, "root"}, "-")] = []byte(responce.RootToken)
+	for i := range len(responce.Keys) {
+		data, err := json.Marshal(keySecret{
+			Key:        []string{responce.Keys[i]},
+			KeyEncoded: []string{responce.KeysB64[i]},
+		})
+		if err != nil {
+			return err
+		}
+		secretData[strings.Join([]string{secretPrefix, strconv.Itoa(i)}, "-")] = data
+	}
+	for i := range len(responce.RecoveryKeys) {
+		data, err := json.Marshal(keySecret{
+			Key:        []string{responce.RecoveryKeys[i]},
+			KeyEncoded: []string{responce.RecoveryKeysB64[i]},
+		})
+		if err != nil {
+			return err
+		}
+		secretData[strings.Join([]string{secretPrefix, "recovery", strconv.Itoa(i)}, "-")] = data
+	}
+
+	ctx := context.Background()
+	var created []string
+	for _, secretName := range slices.Sorted(maps.Keys(secretData)) {
+		newSecret := &coreV1.Secret{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:      secretName,
+				Namespace: configInstance.GetNamespace(),
+				Labels:    labels,
+			},
+			Type: coreV1.SecretTypeOpaque,
+			Data: map[string][]byte{"strdata": secretData[secretName]},
+		}
+		// Create fails if the secret already exists
+		_, err := secretClient.Create(ctx, newSecret, metaV1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to create secret %v (created so far: %v): %v", secretName, created, err)
+		}
+		slog.Debug(fmt.Sprintf("Created secret %v", secretName))
+		created = append(created, secretName)
+	}
+
+	slog.Debug("Storing the init response to kubernetes secrets complete.")
+	return nil
+}
+
+// Store the root token in the root token k8s secret, replacing the old token
+func (configInstance *MonitorConfig) StoreRootTokenSecret(config *rest.Config, rootToken string) error {
+	slog.Debug("Storing the root token to kubernetes secrets")
+	secretClient, err := configInstance.getSecretClient(config)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	secretName := strings.Join([]string{configInstance.getSecretPrefix(), "root"}, "-")
+	secret, err := secretClient.Get(ctx, secretName, metaV1.GetOptions{})
+	if errors.IsNotFound(err) {
+		newSecret := &coreV1.Secret{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:      secretName,
+				Namespace: configInstance.GetNamespace(),
+				Labels:    map[string]string{secretManagedByLabel: secretManagedByValue},
+			},
+			Type: coreV1.SecretTypeOpaque,
+			Data: map[string][]byte{"strdata": []byte(rootToken)},
+		}
+		_, err = secretClient.Create(ctx, newSecret, metaV1.CreateOptions{})
+	} else if err == nil {
+		secret.Data = map[string][]byte{"strdata": []byte(rootToken)}
+		_, err = secretClient.Update(ctx, secret, metaV1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to store secret %v: %v", secretName, err)
+	}
+
+	slog.Debug(fmt.Sprintf("Stored secret %v", secretName))
+	return nil
+}
+
+// Store the credential created by the bootstrap command in the manager
+// k8s secret. This is either the manager token, or the kubernetes auth role.
+func (configInstance *MonitorConfig) StoreManagerSecret(config *rest.Config) error {
+	slog.Debug("Storing the manager credential to kubernetes secrets")
+	secretClient, err := configInstance.getSecretClient(config)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string][]byte)
+	if token, ok := configInstance.Tokens[ManagerTokenID]; ok {
+		data[managerTokenKey] = []byte(token.Key)
+		data[managerPeriodKey] = []byte(strconv.Itoa(token.Duration))
+	}
+	if configInstance.K8sAuthRole != "" {
+		data[managerRoleKey] = []byte(configInstance.K8sAuthRole)
+		data[managerMountKey] = []byte(configInstance.K8sAuthMount)
+	}
+	if configInstance.RootTokenRevoked {
+		data[managerRootRevokedKey] = []byte("true")
+	}
+
+	ctx := context.Background()
+	secretName := strings.Join([]string{configInstance.getSecretPrefix(), "manager"}, "-")
+	secret, err := secretClient.Get(ctx, secretName, metaV1.GetOptions{})
+	if errors.IsNotFound(err) {
+		newSecret := &coreV1.Secret{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:      secretName,
+				Namespace: configInstance.GetNamespace(),
+				Labels:    map[string]string{secretManagedByLabel: secretManagedByValue},
+			},
+			Type: coreV1.SecretTypeOpaque,
+			Data: data,
+		}
+		_, err = secretClient.Create(ctx, newSecret, metaV1.CreateOptions{})
+	} else if err == nil {
+		secret.Data = data
+		_, err = secretClient.Update(ctx, secret, metaV1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("unable to store secret %v: %v", secretName, err)
+	}
+
+	slog.Debug(fmt.Sprintf("Stored secret %v", secretName))
+	return nil
+}
+
+// Delete the root token k8s secret after the root token was revoked
+func (configInstance *MonitorConfig) DeleteRootTokenSecret(config *rest.Config) error {
+	secretClient, err := configInstance.getSecretClient(config)
+	if err != nil {
+		return err
+	}
+
+	secretName := strings.Join([]string{configInstance.getSecretPrefix(), "root"}, "-")
+	err = secretClient.Delete(context.Background(), secretName, metaV1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete secret %v: %v", secretName, err)
+	}
+
+	slog.Debug(fmt.Sprintf("Deleted secret %v", secretName))
+	return nil
+}
+
+// Replace the unseal key shards in k8s secrets with the new keys from a rekey.
+// The secrets of the existing shards are updated, and the secrets of shards
+// beyond the new number of shares are deleted. The recovery key shards and
+// the root token are kept. Until all the secrets are replaced, the new key
+// shards are read from the single secret <SecretPrefix>-rekey.
+func (configInstance *MonitorConfig) StoreRekeySecrets(config *rest.Config, dnshost string, keys []string, keysB64 []string) error {
+	slog.Debug("Storing the rekeyed unseal key shards to kubernetes secrets")
+	secretPrefix := configInstance.getSecretPrefix()
+	if len(keys) != len(keysB64) {
+		return fmt.Errorf("the rekey returned %v keys but %v base64 keys", len(keys), len(keysB64))
+	}
+	secretClient, err := configInstance.getSecretClient(config)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	secrets, err := secretClient.List(ctx, metaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	shardSecret := shardSecretRegex(secretPrefix)
+	existing := make(map[string]coreV1.Secret)
+	for _, secret := range secrets.Items {
+		if shardSecret.MatchString(secret.ObjectMeta.Name) {
+			existing[secret.ObjectMeta.Name] = secret
+		}
+	}
+
+	labels := map[string]string{secretManagedByLabel: secretManagedByValue}
+	if len(validation.IsValidLabelValue(dnshost)) == 0 {
+		labels[secretInstanceLabel] = dnshost
+	}
+
+	// Collect all secrets before changing any of them
+	secretData := make(map[string][]byte)
+	for i := range len(keys) {
+		data, err := json.Marshal(keySecret{
+			Key:        []string{keys[i]},
+			KeyEncoded: []string{keysB64[i]},
+		})
+		if err != nil {
+			return err
+		}
+		secretData[strings.Join([]string{secretPrefix, strconv.Itoa(i)}, "-")] = data
+	}
+
+	// Kubernetes cannot replace several secrets at once. All the new key
+	// shards are stored in a single secret first, which is used in place of
+	// the secrets of the key shards until they are all replaced, so that the
+	// old and new key shards are never mixed.
+	rekeySecretName := strings.Join([]string{secretPrefix, rekeySecretSuffix}, "-")
+	rekeyData, err := json.Marshal(keySecret{Key: keys, KeyEncoded: keysB64})
+	if err != nil {
+		return err
+	}
+	err = configInstance.putSecret(ctx, secretClient, rekeySecretName, rekeyData, labels)
+	if err != nil {
+		return fmt.Errorf("unable to store secret %v: %v", rekeySecretName, err)
+	}
+	slog.Debug(fmt.Sprintf("Stored secret %v", rekeySecretName))
+
+	var stored []string
+	for _, secretName := range slices.Sorted(maps.Keys(secretData)) {
+		err = configInstance.putSecret(ctx, secretClient, secretName, secretData[secretName], labels)
+		if err != nil {
+			return fmt.Errorf("unable to store secret %v (stored so far: %v, the new key shards are in %v): %v",
+				secretName, stored, rekeySecretName, err)
+		}
+		slog.Debug(fmt.Sprintf("Stored secret %v", secretName))
+		stored = append(stored, secretName)
+	}
+
+	// Remove the shards that are no longer part of the key
+	for _, secretName := range slices.Sorted(maps.Keys(existing)) {
+		if _, ok := secretData[secretName]; ok {
+			continue
+		}
+		err := secretClient.Delete(ctx, secretName, metaV1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to delete the old secret %v (the new key shards are in %v): %v",
+				secretName, rekeySecretName, err)
+		}
+		slog.Debug(fmt.Sprintf("Deleted secret %v", secretName))
+	}
+
+	err = secretClient.Delete(ctx, rekeySecretName, metaV1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete secret %v: %v", rekeySecretName, err)
+	}
+
+	slog.Debug("Storing the rekeyed unseal key shards complete.")
+	return nil
+}
+
+// Get the regex matching the names of the secrets of the key shards
+func shardSecretRegex(secretPrefix string) *regexp.Regexp {
+	return regexp.MustCompile("^" + regexp.QuoteMeta(secretPrefix) + `-(\d+)$`)
+}
+
+// Create the secret, or replace the data of the secret if it exists
+func (configInstance *MonitorConfig) putSecret(ctx context.Context, secretClient typedCoreV1.SecretInterface,
+	secretName string, data []byte, labels map[string]string) error {
+	secret, err := secretClient.Get(ctx, secretName, metaV1.GetOptions{})
+	if err == nil {
+		secret.Data = map[string][]byte{"strdata": data}
+		_, err = secretClient.Update(ctx, secret, metaV1.UpdateOptions{})
+		return err
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	newSecret := &coreV1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      secretName,
+			Namespace: configInstance.GetNamespace(),
+			Labels:    labels,
+		},
+		Type: coreV1.SecretTypeOpaque,
+		Data: map[string][]byte{"strdata": data},
+	}
+	_, err = secretClient.Create(ctx, newSecret, metaV1.CreateOptions{})
+	return err
+}
+
 // Get both configs
 func (configInstance *MonitorConfig) MigrateK8sConfig(config *rest.Config) error {
 
@@ -174,5 +732,9 @@ func (configInstance *MonitorConfig) MigrateK8sConfig(config *rest.Config) error
 		return err
 	}
 
+	for _, key := range []string{"ServerAddresses", "Tokens", "UnsealKeyShards"} {
+		configInstance.setSource(key, SourceKubernetes)
+	}
+
 	return nil
 }
@@ -0,0 +1,337 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoConfig
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/go-yaml/yaml"
+)
+
+// Sources of the values of the monitor config. The config is read in layers:
+// the defaults, then the config file, then the environment variables, then
+// the command line.
+const (
+	SourceDefault    string = "default"
+	SourceFile       string = "file"
+	SourceEnv        string = "env"
+	SourceFlag       string = "flag"
+	SourceKubernetes string = "kubernetes"
+)
+
+// Prefix of the environment variables overriding the config.
+// Example: BAOMON_LOG_LEVEL overrides logLevel.
+var envOverridePrefix string = "BAOMON_"
+
+// Value shown in place of the secrets
+var redactedValue string = "<redacted>"
+
+// The value of a config field given by an environment variable or the
+// command line, with its source
+type ConfigOverride struct {
+	Value  string
+	Source string
+}
+
+// A config field overridden by an environment variable or the command line.
+// The value from the config file is written back in place of the override.
+type override struct {
+	source string
+
+	// The value from the config file
+	fileValue reflect.Value
+
+	// The YAML of the value after reading the config, to detect the changes
+	// made by the monitor
+	value []byte
+}
+
+// A field of the monitor config with the source of its value
+type ConfigField struct {
+	Name   string
+	Value  interface{}
+	Source string
+}
+
+// Get the YAML key of a field of the monitor config.
+// Returns "" for the fields that are not read from the config file.
+func yamlKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "" || key == "-" || field.Name == "EncryptedSecrets" || field.Name == "APIVersion" {
+		return ""
+	}
+	return key
+}
+
+// Get the fields of the monitor config by YAML key
+func configFields() map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	configType := reflect.TypeOf(MonitorConfig{})
+	for i := range configType.NumField() {
+		field := configType.Field(i)
+		if key := yamlKey(field); key != "" {
+			fields[key] = field
+		}
+	}
+	return fields
+}
+
+// Get the name of the environment variable overriding a YAML key.
+// The words of the key are separated by underscores: K8sAuthRole is
+// overridden by BAOMON_K8S_AUTH_ROLE, and CACert by BAOMON_CA_CERT.
+func EnvOverrideName(key string) string {
+	runes := []rune(key)
+	var name strings.Builder
+	name.WriteString(envOverridePrefix)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				name.WriteRune('_')
+			}
+		}
+		name.WriteRune(unicode.ToUpper(r))
+	}
+	return name.String()
+}
+
+// Get the overrides of the config, by YAML key: the BAOMON_* environment
+// variables, then the overrides from the command line, given as Key=Value
+// with the YAML key of the field. The values of maps are given in YAML.
+func GetOverrides(flags []string) (map[string]ConfigOverride, error) {
+	fields := configFields()
+	overrides := make(map[string]ConfigOverride)
+	for key := range fields {
+		if envValue, ok := os.LookupEnv(EnvOverrideName(key)); ok {
+			overrides[key] = ConfigOverride{Value: envValue, Source: SourceEnv}
+		}
+	}
+	for _, keyValue := range flags {
+		key, value, found := strings.Cut(keyValue, "=")
+		if !found {
+			return nil, fmt.Errorf("the override %v must be given as Key=Value", keyValue)
+		}
+		matched := ""
+		for fieldKey := range fields {
+			if strings.EqualFold(fieldKey, key) {
+				matched = fieldKey
+			}
+		}
+		if matched == "" {
+			return nil, fmt.Errorf("unknown config key %v in the override %v", key, keyValue)
+		}
+		overrides[matched] = ConfigOverride{Value: value, Source: SourceFlag}
+	}
+
+	return overrides, nil
+}
+
+// Parse an override value into a field. Strings are used as given, the other
+// types are parsed as YAML.
+func parseOverride(fieldType reflect.Type, value string) (reflect.Value, error) {
+	parsed := reflect.New(fieldType)
+	if fieldType.Kind() == reflect.String {
+		parsed.Elem().SetString(value)
+		return parsed.Elem(), nil
+	}
+	err := yaml.Unmarshal([]byte(value), parsed.Interface())
+	if err != nil {
+		return parsed.Elem(), err
+	}
+	return parsed.Elem(), nil
+}
+
+// Apply the overrides over the values from the config file. fileKeys are
+// the keys that were set in the config file.
+func (configInstance *MonitorConfig) applyOverrides(fileKeys map[string]bool, overrides map[string]ConfigOverride) error {
+	configInstance.sources = make(map[string]string)
+	configInstance.overrides = make(map[string]*override)
+	configValue := reflect.ValueOf(configInstance).Elem()
+
+	for key, field := range configFields() {
+		if fileKeys[key] {
+			configInstance.sources[key] = SourceFile
+		} else {
+			configInstance.sources[key] = SourceDefault
+		}
+
+		value, ok := overrides[key]
+		if !ok {
+			continue
+		}
+
+		parsed, err := parseOverride(field.Type, value.Value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q from %v for %v: %v", value.Value, value.Source, key, err)
+		}
+		slog.Debug(fmt.Sprintf("Overriding %v from %v", key, value.Source))
+		fieldValue := configValue.FieldByIndex(field.Index)
+		fileValue := reflect.New(field.Type).Elem()
+		fileValue.Set(fieldValue)
+		configInstance.overrides[key] = &override{source: value.Source, fileValue: fileValue}
+		configInstance.sources[key] = value.Source
+		fieldValue.Set(parsed)
+	}
+
+	return nil
+}
+
+// Record the overridden values once the config is read, to tell them
+// apart from the changes made by the monitor when the config is written
+func (configInstance *MonitorConfig) recordOverrides() error {
+	configValue := reflect.ValueOf(configInstance).Elem()
+	fields := configFields()
+	for key, override := range configInstance.overrides {
+		value, err := yaml.Marshal(configValue.FieldByIndex(fields[key].Index).Interface())
+		if err != nil {
+			return err
+		}
+		override.value = value
+	}
+
+	return nil
+}
+
+// Get the field holding the value of the config file for key: the saved
+// value when the field is overridden, or the field itself otherwise
+func (configInstance *MonitorConfig) fileField(key string) reflect.Value {
+	if override, ok := configInstance.overrides[key]; ok {
+		return override.fileValue
+	}
+	return reflect.ValueOf(configInstance).Elem().FieldByIndex(configFields()[key].Index)
+}
+
+// Apply the changes the monitor made to the overridden Tokens to the tokens
+// of the config file, so that the tokens given by the override are never
+// written: a removed token is removed from the file, a changed token is
+// updated only when the file holds the same key, and a token added by the
+// monitor is written.
+func mergeTokenChanges(fileTokens map[string]Token, recorded []byte, current map[string]Token) (map[string]Token, error) {
+	var overridden map[string]Token
+	err := yaml.Unmarshal(recorded, &overridden)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]Token)
+	for tokenID, token := range fileTokens {
+		_, wasOverridden := overridden[tokenID]
+		_, kept := current[tokenID]
+		if kept || !wasOverridden {
+			merged[tokenID] = token
+		}
+	}
+	for tokenID, token := range current {
+		fileToken, inFile := fileTokens[tokenID]
+		if _, wasOverridden := overridden[tokenID]; !wasOverridden || (inFile && fileToken.Key == token.Key) {
+			merged[tokenID] = token
+		}
+	}
+	if fileTokens == nil && len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
+
+// Put back the values of the config file in place of the overrides, so that
+// the overrides are not written to the config file. A value that the monitor
+// changed is written, and replaces the value of the config file. The
+// overridden secrets are never written: only the changes of the monitor to
+// the tokens of the config file are, and a change of the overridden
+// UnsealKeyShards is not written.
+func (configInstance *MonitorConfig) restoreFileValues() {
+	configValue := reflect.ValueOf(configInstance).Elem()
+	fields := configFields()
+	for key, override := range configInstance.overrides {
+		fieldValue := configValue.FieldByIndex(fields[key].Index)
+		current, err := yaml.Marshal(fieldValue.Interface())
+		if err != nil || bytes.Equal(current, override.value) {
+			fieldValue.Set(override.fileValue)
+			continue
+		}
+
+		switch key {
+		case "Tokens":
+			merged, err := mergeTokenChanges(override.fileValue.Interface().(map[string]Token), override.value,
+				fieldValue.Interface().(map[string]Token))
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Unable to apply the changes of the overridden Tokens: %v. "+
+					"The changes are not written to the config file.", err))
+			} else {
+				slog.Info("The overridden Tokens were changed. Writing the changes to the tokens of the config file.")
+				override.fileValue.Set(reflect.ValueOf(merged))
+			}
+			fieldValue.Set(override.fileValue)
+		case "UnsealKeyShards":
+			slog.Warn("The overridden UnsealKeyShards were changed. " +
+				"The change is not written to the config file, to keep the overridden secrets out of it.")
+			fieldValue.Set(override.fileValue)
+		default:
+			slog.Info(fmt.Sprintf("The overridden %v was changed. Writing the changed value to the config file.", key))
+			override.fileValue.Set(fieldValue)
+		}
+		override.value = current
+	}
+}
+
+// Set the source of a field whose value was replaced
+func (configInstance *MonitorConfig) setSource(key string, source string) {
+	if configInstance.sources != nil {
+		configInstance.sources[key] = source
+	}
+}
+
+// Get the value of a field with the secrets redacted
+func redactField(key string, value interface{}) interface{} {
+	switch key {
+	case "Tokens":
+		redacted := make(map[string]Token)
+		for tokenID, token := range value.(map[string]Token) {
+			token.Key = redactedValue
+			redacted[tokenID] = token
+		}
+		return redacted
+	case "UnsealKeyShards":
+		redacted := make(map[string]KeyShards)
+		for shardName := range value.(map[string]KeyShards) {
+			redacted[shardName] = KeyShards{Key: redactedValue, KeyBase64: redactedValue}
+		}
+		return redacted
+	}
+	return value
+}
+
+// Get the fields of the monitor config in the order of the config file,
+// with the source of their values. The secrets are redacted.
+func (configInstance MonitorConfig) EffectiveConfig() []ConfigField {
+	var fields []ConfigField
+	configValue := reflect.ValueOf(configInstance)
+	configType := configValue.Type()
+	for i := range configType.NumField() {
+		key := yamlKey(configType.Field(i))
+		if key == "" {
+			continue
+		}
+		source := configInstance.sources[key]
+		if source == "" {
+			source = SourceDefault
+		}
+		fields = append(fields, ConfigField{
+			Name:   key,
+			Value:  redactField(key, configValue.Field(i).Interface()),
+			Source: source,
+		})
+	}
+	return fields
+}
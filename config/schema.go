@@ -0,0 +1,286 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoConfig
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+// A problem found in the config file. Line is the line of the YAML the
+// problem is on, or 0 when it is not on a line of the config file, such as
+// a problem with an override. Path holds the keys from the top of the config
+// to the value with the problem, in the v1 format, and Key joins them.
+type ConfigError struct {
+	Line    int
+	Key     string
+	Path    []string
+	Message string
+}
+
+// Create a problem with the value at the key path of the config
+func newConfigError(path []string, format string, args ...interface{}) ConfigError {
+	return ConfigError{
+		Key:     strings.Join(path, "."),
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (configError ConfigError) Error() string {
+	if configError.Line > 0 {
+		return fmt.Sprintf("line %v: %v", configError.Line, configError.Message)
+	}
+	return configError.Message
+}
+
+// All the problems found in the config file
+type ConfigErrors []ConfigError
+
+func (configErrors ConfigErrors) Error() string {
+	messages := make([]string, len(configErrors))
+	for i, configError := range configErrors {
+		messages[i] = configError.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Get the problems as an error, or nil if there are none
+func (configErrors ConfigErrors) asError() error {
+	if len(configErrors) == 0 {
+		return nil
+	}
+	return configErrors
+}
+
+// Collects the problems found in a config file, along with their lines
+type configChecker struct {
+	errors ConfigErrors
+
+	// The lines of the settings of the config file, by their keys in the v1
+	// format
+	keyLines map[string]int
+
+	// The root node of the config file, nil for an empty file
+	root *yamlv3.Node
+
+	// Whether the config file is in the v2 format
+	v2 bool
+
+	// The settings overridden by the environment or the command line, whose
+	// values are not on a line of the config file
+	overridden map[string]bool
+}
+
+// Parse the config file to find the lines of its keys. Returns the root
+// node of the config file, which is nil for an empty file.
+func newConfigChecker(data []byte) (*configChecker, *yamlv3.Node, error) {
+	checker := &configChecker{keyLines: make(map[string]int)}
+	var document yamlv3.Node
+	err := yamlv3.Unmarshal(data, &document)
+	if err != nil {
+		return nil, nil, err
+	}
+	if document.Kind != yamlv3.DocumentNode || len(document.Content) == 0 {
+		return checker, nil, nil
+	}
+
+	root := document.Content[0]
+	checker.root = root
+	if root.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			checker.keyLines[root.Content[i].Value] = root.Content[i].Line
+		}
+	}
+	return checker, root, nil
+}
+
+// Get the mapping node of a YAML node, following the aliases.
+// Returns nil if the node is not a mapping.
+func mappingNode(node *yamlv3.Node) *yamlv3.Node {
+	if node != nil && node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	return node
+}
+
+// Get the line of the value at a key path of the config, in the v1 format.
+// The line of the deepest key of the path found in the config file is
+// returned, so that a missing nested key points to its parent. Returns 0
+// when the setting is not in the config file, or is overridden.
+func (checker *configChecker) line(path []string) int {
+	if len(path) == 0 || checker.overridden[path[0]] {
+		return 0
+	}
+	// The keys that must be found, for the setting to be in the config file
+	required := 1
+	if checker.v2 {
+		v2Path, ok := v2KeyPaths()[path[0]]
+		if !ok {
+			return 0
+		}
+		path = append(slices.Clone(v2Path), path[1:]...)
+		required = len(v2Path)
+	}
+
+	line := 0
+	node := checker.root
+	for depth, key := range path {
+		node = mappingNode(node)
+		if node == nil {
+			break
+		}
+		var value *yamlv3.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line, value = node.Content[i].Line, node.Content[i+1]
+				break
+			}
+		}
+		if value == nil {
+			if depth < required {
+				return 0
+			}
+			break
+		}
+		node = value
+	}
+	return line
+}
+
+// Add a problem with the value of key. Nothing is added if err is nil.
+func (checker *configChecker) add(key string, err error) {
+	if err == nil {
+		return
+	}
+	var path []string
+	if key != "" {
+		path = []string{key}
+	}
+	checker.errors = append(checker.errors, ConfigError{
+		Line:    checker.line(path),
+		Key:     key,
+		Path:    path,
+		Message: err.Error(),
+	})
+}
+
+// Add the problems found by a validation, each on the line of its key path
+func (checker *configChecker) addErrors(configErrors ConfigErrors) {
+	for _, configError := range configErrors {
+		if configError.Line == 0 {
+			configError.Line = checker.line(configError.Path)
+		}
+		checker.errors = append(checker.errors, configError)
+	}
+}
+
+// Add the problems of the unmarshal of the config file, each with its line.
+// Returns false if the config file could not be unmarshaled at all.
+func (checker *configChecker) addUnmarshalError(err error) bool {
+	var typeError *yaml.TypeError
+	if !errors.As(err, &typeError) {
+		checker.errors = append(checker.errors, ConfigError{Message: err.Error()})
+		return false
+	}
+	for _, message := range typeError.Errors {
+		configError := ConfigError{Message: message}
+		var line int
+		var rest string
+		if n, _ := fmt.Sscanf(message, "line %d: %s", &line, &rest); n == 2 {
+			configError.Line = line
+			configError.Message = strings.SplitN(message, ": ", 2)[1]
+		}
+		checker.errors = append(checker.errors, configError)
+	}
+	return true
+}
+
+// Get the problems found in the order of their lines, or nil if there are
+// none. The problems that are not on a line come first.
+func (checker *configChecker) err() error {
+	slices.SortStableFunc(checker.errors, func(a, b ConfigError) int {
+		return cmp.Compare(a.Line, b.Line)
+	})
+	return checker.errors.asError()
+}
+
+// Check the keys of a YAML mapping against the yaml tags of the type the
+// mapping is unmarshaled into. Unknown and duplicate keys are reported.
+func (checker *configChecker) checkKeys(node *yamlv3.Node, valueType reflect.Type, path string) {
+	for valueType.Kind() == reflect.Pointer {
+		valueType = valueType.Elem()
+	}
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	// The other kinds of values are checked by the unmarshal
+	if node.Kind != yamlv3.MappingNode {
+		return
+	}
+
+	known := make(map[string]reflect.Type)
+	if valueType.Kind() == reflect.Struct {
+		for i := range valueType.NumField() {
+			field := valueType.Field(i)
+			key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if key != "" && key != "-" {
+				known[key] = field.Type
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		keyPath := keyNode.Value
+		if path != "" {
+			keyPath = path + "." + keyNode.Value
+		}
+		if seen[keyNode.Value] {
+			checker.errors = append(checker.errors, ConfigError{
+				Line:    keyNode.Line,
+				Key:     keyPath,
+				Message: fmt.Sprintf("duplicate key %v", keyPath),
+			})
+			continue
+		}
+		seen[keyNode.Value] = true
+
+		switch valueType.Kind() {
+		case reflect.Map:
+			checker.checkKeys(valueNode, valueType.Elem(), keyPath)
+		case reflect.Struct:
+			fieldType, ok := known[keyNode.Value]
+			if ok {
+				checker.checkKeys(valueNode, fieldType, keyPath)
+				continue
+			}
+			message := fmt.Sprintf("unknown key %v", keyPath)
+			for knownKey := range known {
+				if strings.EqualFold(knownKey, keyNode.Value) {
+					message = fmt.Sprintf("%v. Did you mean %v?", message, knownKey)
+				}
+			}
+			checker.errors = append(checker.errors, ConfigError{
+				Line:    keyNode.Line,
+				Key:     keyPath,
+				Message: message,
+			})
+		}
+	}
+}
@@ -0,0 +1,362 @@
+//
+// Copyright (c) 2025 Wind River Systems, Inc.
+//
+// SPDX-License-Identifier: Apache-2.0
+//
+
+package baoConfig
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+// Versions of the config file format.
+// The v1 format is the flat MonitorConfig, and is deprecated. The config
+// files without an apiVersion are in the v1 format.
+const (
+	APIVersionV1 string = "v1"
+	APIVersionV2 string = "v2"
+)
+
+// The deprecation of the v1 format is reported once
+var v1Warned bool = false
+
+// The config file in the v2 format, with the settings grouped in sections.
+// Each setting of a section has the key of the same setting in the v1
+// format as its v1 tag.
+type configV2 struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Server     serverConfigV2     `yaml:"server"`
+	TLS        tlsConfigV2        `yaml:"tls"`
+	Logging    loggingConfigV2    `yaml:"logging"`
+	Kubernetes kubernetesConfigV2 `yaml:"kubernetes"`
+	Secrets    secretsConfigV2    `yaml:"secrets"`
+	Run        runConfigV2        `yaml:"run"`
+	Raft       raftConfigV2       `yaml:"raft"`
+}
+
+// The servers, and how to reach them
+type serverConfigV2 struct {
+	Addresses   map[string]ServerAddress `yaml:"addresses" v1:"ServerAddresses"`
+	Clusters    map[string]string        `yaml:"clusters" v1:"ServerClusters"`
+	DefaultPort int                      `yaml:"defaultPort" v1:"DefaultPort"`
+	Timeout     int                      `yaml:"timeout" v1:"Timeout"`
+}
+
+// The certificates of the connections to the servers
+type tlsConfigV2 struct {
+	CACert     string `yaml:"caCert" v1:"CACert"`
+	ClientCert string `yaml:"clientCert" v1:"ClientCert"`
+	ClientKey  string `yaml:"clientKey" v1:"ClientKey"`
+}
+
+// The logs of the monitor
+type loggingConfigV2 struct {
+	Path  string `yaml:"path" v1:"logPath"`
+	Level string `yaml:"level" v1:"logLevel"`
+}
+
+// The discovery of the server pods, the kubernetes secrets and the
+// kubernetes auth method
+type kubernetesConfigV2 struct {
+	Namespace           string `yaml:"namespace" v1:"Namespace"`
+	PodPrefix           string `yaml:"podPrefix" v1:"PodPrefix"`
+	PodLabelSelector    string `yaml:"podLabelSelector" v1:"PodLabelSelector"`
+	StatefulSetName     string `yaml:"statefulSetName" v1:"StatefulSetName"`
+	HeadlessServiceName string `yaml:"headlessServiceName" v1:"HeadlessServiceName"`
+	WatchPods           bool   `yaml:"watchPods" v1:"WatchPods"`
+	PodAddressSuffix    string `yaml:"podAddressSuffix" v1:"PodAddressSuffix"`
+	SecretPrefix        string `yaml:"secretPrefix" v1:"SecretPrefix"`
+	AuthMount           string `yaml:"authMount" v1:"K8sAuthMount"`
+	AuthRole            string `yaml:"authRole" v1:"K8sAuthRole"`
+	AuthTokenPath       string `yaml:"authTokenPath" v1:"K8sAuthTokenPath"`
+}
+
+// The tokens and the unseal key shards, and how they are used and stored
+type secretsConfigV2 struct {
+	Tokens                  map[string]Token     `yaml:"tokens" v1:"Tokens"`
+	UnsealKeyShards         map[string]KeyShards `yaml:"unsealKeyShards" v1:"UnsealKeyShards"`
+	ShardOwners             map[string]string    `yaml:"shardOwners" v1:"ShardOwners"`
+	RootTokenRevoked        bool                 `yaml:"rootTokenRevoked" v1:"RootTokenRevoked"`
+	TokenSource             string               `yaml:"tokenSource" v1:"TokenSource"`
+	TokenName               string               `yaml:"tokenName" v1:"TokenName"`
+	TokenEnv                string               `yaml:"tokenEnv" v1:"TokenEnv"`
+	TokenFile               string               `yaml:"tokenFile" v1:"TokenFile"`
+	EncryptionKeyFile       string               `yaml:"encryptionKeyFile" v1:"EncryptionKeyFile"`
+	EncryptionPassphraseEnv string               `yaml:"encryptionPassphraseEnv" v1:"EncryptionPassphraseEnv"`
+	Encrypted               *EncryptedSecrets    `yaml:"encrypted,omitempty" v1:"EncryptedSecrets"`
+}
+
+// The run command
+type runConfigV2 struct {
+	WaitInterval      int    `yaml:"waitInterval" v1:"WaitInterval"`
+	MaxConcurrency    int    `yaml:"maxConcurrency" v1:"MaxConcurrency"`
+	MetricsAddress    string `yaml:"metricsAddress" v1:"MetricsAddress"`
+	ProbeAddress      string `yaml:"probeAddress" v1:"ProbeAddress"`
+	LivenessIntervals int    `yaml:"livenessIntervals" v1:"LivenessIntervals"`
+	LeaderElection    bool   `yaml:"leaderElection" v1:"LeaderElection"`
+	LeaseName         string `yaml:"leaseName" v1:"LeaseName"`
+	LeaseDuration     int    `yaml:"leaseDuration" v1:"LeaseDuration"`
+	LeaderLockFile    string `yaml:"leaderLockFile" v1:"LeaderLockFile"`
+	ConfigBackups     int    `yaml:"configBackups" v1:"ConfigBackups"`
+}
+
+// The raft peers and snapshots
+type raftConfigV2 struct {
+	PeerGracePeriod        int    `yaml:"peerGracePeriod" v1:"RaftPeerGracePeriod"`
+	PruneDryRun            bool   `yaml:"pruneDryRun" v1:"RaftPruneDryRun"`
+	SnapshotDirectory      string `yaml:"snapshotDirectory" v1:"SnapshotDirectory"`
+	SnapshotInterval       int    `yaml:"snapshotInterval" v1:"SnapshotInterval"`
+	SnapshotRetentionCount int    `yaml:"snapshotRetentionCount" v1:"SnapshotRetentionCount"`
+	SnapshotRetentionAge   int    `yaml:"snapshotRetentionAge" v1:"SnapshotRetentionAge"`
+}
+
+// Check that the settings of the v2 format match the monitor config, so that
+// a setting added to one but not the other fails at startup rather than on
+// the config of a user. Each v1 tag of the sections must be the yaml key of
+// a monitor config field of the same type, and each yaml key of the monitor
+// config must be the v1 tag of a section setting, except the apiVersion.
+func init() {
+	configType := reflect.TypeOf(MonitorConfig{})
+	v1Types := make(map[string]reflect.Type)
+	for i := range configType.NumField() {
+		key := strings.Split(configType.Field(i).Tag.Get("yaml"), ",")[0]
+		if key != "" && key != "-" && configType.Field(i).Name != "APIVersion" {
+			v1Types[key] = configType.Field(i).Type
+		}
+	}
+
+	mapped := make(map[string]bool)
+	v2Type := reflect.TypeOf(configV2{})
+	for i := range v2Type.NumField() {
+		sectionType := v2Type.Field(i).Type
+		if sectionType.Kind() != reflect.Struct {
+			continue
+		}
+		for j := range sectionType.NumField() {
+			field := sectionType.Field(j)
+			v1Key := field.Tag.Get("v1")
+			v1Type, ok := v1Types[v1Key]
+			if !ok {
+				panic(fmt.Sprintf("the v2 setting %v.%v has the v1 tag %q, which is not a key of the monitor config",
+					sectionType.Name(), field.Name, v1Key))
+			}
+			if v1Type != field.Type {
+				panic(fmt.Sprintf("the v2 setting %v.%v is a %v, but the monitor config key %v is a %v",
+					sectionType.Name(), field.Name, field.Type, v1Key, v1Type))
+			}
+			if mapped[v1Key] {
+				panic(fmt.Sprintf("the monitor config key %v is the v1 tag of more than one v2 setting", v1Key))
+			}
+			mapped[v1Key] = true
+		}
+	}
+
+	for key := range v1Types {
+		if !mapped[key] {
+			panic(fmt.Sprintf("the monitor config key %v is not the v1 tag of any v2 setting", key))
+		}
+	}
+}
+
+// Copy the settings between the monitor config and the sections of the v2
+// format, matching the v1 tags of the sections with the yaml keys of the
+// monitor config
+func convertV2(config *MonitorConfig, v2 *configV2, toV2 bool) {
+	configValue := reflect.ValueOf(config).Elem()
+	v1Fields := make(map[string]reflect.Value)
+	for i := range configValue.NumField() {
+		key := strings.Split(configValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if key != "" && key != "-" {
+			v1Fields[key] = configValue.Field(i)
+		}
+	}
+
+	v2Value := reflect.ValueOf(v2).Elem()
+	for i := range v2Value.NumField() {
+		section := v2Value.Field(i)
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		for j := range section.NumField() {
+			v1Field := v1Fields[section.Type().Field(j).Tag.Get("v1")]
+			if toV2 {
+				section.Field(j).Set(v1Field)
+			} else {
+				v1Field.Set(section.Field(j))
+			}
+		}
+	}
+}
+
+// Get the monitor config in the v2 format
+func (configInstance MonitorConfig) toV2() configV2 {
+	v2 := configV2{APIVersion: APIVersionV2}
+	convertV2(&configInstance, &v2, true)
+	return v2
+}
+
+// Set the monitor config from the v2 format
+func (configInstance *MonitorConfig) fromV2(v2 configV2) {
+	convertV2(configInstance, &v2, false)
+	configInstance.APIVersion = APIVersionV2
+}
+
+// Get the version of the format of a config file
+func fileAPIVersion(root *yamlv3.Node) string {
+	if root == nil || root.Kind != yamlv3.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "apiVersion" {
+			return root.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// Get the key paths of the settings in the v2 format, section then key,
+// by their keys in the v1 format
+func v2KeyPaths() map[string][]string {
+	paths := make(map[string][]string)
+	v2Type := reflect.TypeOf(configV2{})
+	for i := range v2Type.NumField() {
+		sectionType := v2Type.Field(i).Type
+		if sectionType.Kind() != reflect.Struct {
+			continue
+		}
+		section := strings.Split(v2Type.Field(i).Tag.Get("yaml"), ",")[0]
+		for j := range sectionType.NumField() {
+			field := sectionType.Field(j)
+			paths[field.Tag.Get("v1")] = []string{section, strings.Split(field.Tag.Get("yaml"), ",")[0]}
+		}
+	}
+	return paths
+}
+
+// Find the lines of the settings of a config file in the v2 format, by
+// their keys in the v1 format
+func (checker *configChecker) setV2KeyLines(root *yamlv3.Node) {
+	checker.v2 = true
+	checker.keyLines = make(map[string]int)
+	sections := make(map[string]reflect.Type)
+	v2Type := reflect.TypeOf(configV2{})
+	for i := range v2Type.NumField() {
+		sections[strings.Split(v2Type.Field(i).Tag.Get("yaml"), ",")[0]] = v2Type.Field(i).Type
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		sectionType, ok := sections[root.Content[i].Value]
+		sectionNode := root.Content[i+1]
+		if !ok || sectionType.Kind() != reflect.Struct || sectionNode.Kind != yamlv3.MappingNode {
+			continue
+		}
+		v1Keys := make(map[string]string)
+		for j := range sectionType.NumField() {
+			field := sectionType.Field(j)
+			v1Keys[strings.Split(field.Tag.Get("yaml"), ",")[0]] = field.Tag.Get("v1")
+		}
+		for j := 0; j+1 < len(sectionNode.Content); j += 2 {
+			if v1Key, ok := v1Keys[sectionNode.Content[j].Value]; ok {
+				checker.keyLines[v1Key] = sectionNode.Content[j].Line
+			}
+		}
+	}
+}
+
+// Unmarshal a config file in either format into the monitor config.
+// The problems found are added to the checker. Returns false if the config
+// file could not be unmarshaled at all.
+func (configInstance *MonitorConfig) unmarshalVersion(data []byte, checker *configChecker, root *yamlv3.Node) bool {
+	switch version := fileAPIVersion(root); version {
+	case "", APIVersionV1:
+		if !v1Warned {
+			v1Warned = true
+			slog.Warn("The config file is in the deprecated v1 format. " +
+				"Convert it to the v2 format with: baomon config migrate")
+		}
+		err := yaml.Unmarshal(data, configInstance)
+		if err != nil && !checker.addUnmarshalError(err) {
+			return false
+		}
+		if root != nil {
+			checker.checkKeys(root, reflect.TypeOf(*configInstance), "")
+		}
+	case APIVersionV2:
+		var v2 configV2
+		err := yaml.Unmarshal(data, &v2)
+		if err != nil && !checker.addUnmarshalError(err) {
+			return false
+		}
+		checker.checkKeys(root, reflect.TypeOf(v2), "")
+		checker.setV2KeyLines(root)
+		configInstance.fromV2(v2)
+	default:
+		checker.errors = append(checker.errors, ConfigError{
+			Line:    checker.keyLines["apiVersion"],
+			Key:     "apiVersion",
+			Message: fmt.Sprintf("unknown apiVersion %v. Available versions: %v, %v", version, APIVersionV1, APIVersionV2),
+		})
+		return false
+	}
+
+	return true
+}
+
+// Convert a config file in the v1 format to the v2 format. The settings are
+// converted as they are in the config file: the defaults are not filled in,
+// and the encrypted secrets are not decrypted. The config file is checked
+// for unknown keys first. Returns the settings of the config file. The YAML
+// comments of the config file are not kept.
+func MigrateYAMLMonitorConfig(in io.Reader, out io.Writer) (MonitorConfig, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return MonitorConfig{}, fmt.Errorf("unable to read the config file: %v", err)
+	}
+	checker, root, err := newConfigChecker(data)
+	if err != nil {
+		return MonitorConfig{}, fmt.Errorf("unable to unmarshal the config file: %v", err)
+	}
+	if version := fileAPIVersion(root); version != "" && version != APIVersionV1 {
+		return MonitorConfig{}, fmt.Errorf("the config file is in the %v format, not %v", version, APIVersionV1)
+	}
+
+	var v1 MonitorConfig
+	err = yaml.Unmarshal(data, &v1)
+	if err != nil {
+		checker.addUnmarshalError(err)
+	}
+	if root != nil {
+		checker.checkKeys(root, reflect.TypeOf(v1), "")
+	}
+	if checker.err() != nil {
+		return MonitorConfig{}, checker.err()
+	}
+
+	// Check that no setting is lost in the conversion
+	v2 := v1.toV2()
+	var converted MonitorConfig
+	converted.fromV2(v2)
+	converted.APIVersion = v1.APIVersion
+	if !reflect.DeepEqual(converted, v1) {
+		return MonitorConfig{}, fmt.Errorf("the conversion to the %v format would lose settings", APIVersionV2)
+	}
+
+	v2Data, err := yaml.Marshal(v2)
+	if err != nil {
+		return MonitorConfig{}, fmt.Errorf("unable to marshal the config in the %v format: %v", APIVersionV2, err)
+	}
+	_, err = out.Write(v2Data)
+	if err != nil {
+		return MonitorConfig{}, fmt.Errorf("unable to write the config in the %v format: %v", APIVersionV2, err)
+	}
+
+	return v1, nil
+}
@@ -1,35 +1,58 @@
 package baoConfig
 
 import (
+	"crypto/tls"
 	"encoding/base64"
-	"fmt"
+	"maps"
 	"os"
 	"path"
 	"regexp"
 	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-func (configInstance MonitorConfig) validateDNS() error {
-	for domain_name, url := range configInstance.ServerAddresses {
+// Limits of the time intervals, in seconds
+var maxWaitInterval int = 86400
+var maxTimeout int = 3600
+
+// Check that the files named in the config exist. This can be disabled to
+// validate a config away from the host the monitor runs on.
+var fileChecks bool = true
+
+// Enable or disable checking the files named in the config
+func SetFileChecks(enabled bool) {
+	fileChecks = enabled
+}
+
+func (configInstance MonitorConfig) validateDNS() ConfigErrors {
+	var configErrors ConfigErrors
+	for _, domain_name := range slices.Sorted(maps.Keys(configInstance.ServerAddresses)) {
 		// If Host is empty, then the domain entry is invalid
 		// The ports will always at least have the default value of 8200
-		if url.Host == "" {
-			return fmt.Errorf(
-				"the domain entry %v in ServerAddresses is invalid", domain_name)
+		if configInstance.ServerAddresses[domain_name].Host == "" {
+			configErrors = append(configErrors, newConfigError(
+				[]string{"ServerAddresses", domain_name, "host"},
+				"the domain entry %v in ServerAddresses is invalid", domain_name))
 		}
 	}
 
-	return nil
+	return configErrors
 }
 
-func (configInstance MonitorConfig) validateTokens() error {
+func (configInstance MonitorConfig) validateTokens() ConfigErrors {
+	var configErrors ConfigErrors
 	rootExists := false
 	r, _ := regexp.Compile("[sbr][.][a-zA-Z0-9]{24,}")
-	for releaseID, token := range configInstance.Tokens {
+	for _, releaseID := range slices.Sorted(maps.Keys(configInstance.Tokens)) {
+		token := configInstance.Tokens[releaseID]
 		if token.Duration == 0 {
 			// There can only be one root token
 			if rootExists {
-				return fmt.Errorf("there are two or more root tokens listed")
+				configErrors = append(configErrors, newConfigError(
+					[]string{"Tokens", releaseID, "duration"},
+					"there are two or more root tokens listed"))
 			} else {
 				rootExists = true
 			}
@@ -37,58 +60,261 @@ func (configInstance MonitorConfig) validateTokens() error {
 		// Token key should have s, b, or r as the first character, and . as the second.
 		// The body of the token (key[2:]) should be 24 characters or more
 		if !r.MatchString(token.Key) {
-			return fmt.Errorf(
-				"the token with release id %v has wrong key format", releaseID)
+			configErrors = append(configErrors, newConfigError(
+				[]string{"Tokens", releaseID, "key"},
+				"the token with release id %v has wrong key format", releaseID))
 		}
 	}
 
-	return nil
+	return configErrors
 }
 
-func (configInstance MonitorConfig) validateKeyShards() error {
-	for shardName, shard := range configInstance.UnsealKeyShards {
+func (configInstance MonitorConfig) validateKeyShards() ConfigErrors {
+	var configErrors ConfigErrors
+	for _, shardName := range slices.Sorted(maps.Keys(configInstance.UnsealKeyShards)) {
+		shard := configInstance.UnsealKeyShards[shardName]
 		// A shard should have both its key and base64 key non-empty
 		if shard.Key == "" || shard.KeyBase64 == "" {
-			return fmt.Errorf("shard %v has missing keys", shardName)
+			configErrors = append(configErrors, newConfigError(
+				[]string{"UnsealKeyShards", shardName},
+				"shard %v has missing keys", shardName))
+			continue
 		}
 		// Base64 encoded keys must be able to be decoded with no errors
 		_, err := base64.StdEncoding.DecodeString(shard.KeyBase64)
 		if err != nil {
-			return fmt.Errorf(
-				"error with validating if %v has a correct base64 encoded key: %v", shardName, err)
+			configErrors = append(configErrors, newConfigError(
+				[]string{"UnsealKeyShards", shardName, "key_base64"},
+				"error with validating if %v has a correct base64 encoded key: %v", shardName, err))
 		}
 	}
 
-	return nil
+	return configErrors
+}
+
+func (configInstance MonitorConfig) validateShardOwners() ConfigErrors {
+	var configErrors ConfigErrors
+	for _, shardName := range slices.Sorted(maps.Keys(configInstance.ShardOwners)) {
+		if configInstance.ShardOwners[shardName] == "" {
+			configErrors = append(configErrors, newConfigError(
+				[]string{"ShardOwners", shardName},
+				"the owner of shard %v in ShardOwners is empty", shardName))
+		}
+	}
+	for _, dnsName := range slices.Sorted(maps.Keys(configInstance.ServerClusters)) {
+		if configInstance.ServerClusters[dnsName] == "" {
+			configErrors = append(configErrors, newConfigError(
+				[]string{"ServerClusters", dnsName},
+				"the cluster of %v in ServerClusters is empty", dnsName))
+		}
+	}
+
+	return configErrors
 }
 
-func (configInstance MonitorConfig) validateLogConfig() error {
-	if configInstance.LogPath != "" {
+func (configInstance MonitorConfig) validateLogConfig() ConfigErrors {
+	var configErrors ConfigErrors
+	if configInstance.LogPath != "" && fileChecks {
 		_, err := os.Stat(path.Dir(configInstance.LogPath))
 		if err != nil {
-			return fmt.Errorf(
-				"error in checking the parent directory of LogPath. Error message: %v", err)
+			configErrors = append(configErrors, newConfigError([]string{"logPath"},
+				"error in checking the parent directory of LogPath. Error message: %v", err))
 		}
 	}
 	if configInstance.LogLevel != "" {
 		availableLogLevels := []string{"DEBUG", "INFO", "WARN", "ERROR"}
 		if !slices.Contains(availableLogLevels, configInstance.LogLevel) {
-			return fmt.Errorf(
-				"the listed LogLevel %v is not a valid log level", configInstance.LogLevel)
+			configErrors = append(configErrors, newConfigError([]string{"logLevel"},
+				"the listed LogLevel %v is not a valid log level", configInstance.LogLevel))
 		}
 	}
 
-	return nil
+	return configErrors
 }
 
-func (configInstance MonitorConfig) validateCACert() error {
-	if configInstance.CACert != "" {
+func (configInstance MonitorConfig) validateCACert() ConfigErrors {
+	if configInstance.CACert != "" && fileChecks {
 		_, err := os.Stat(configInstance.CACert)
 		if err != nil {
-			return fmt.Errorf(
-				"error in checking the path of CACert. Error message: %v", err)
+			return ConfigErrors{newConfigError([]string{"CACert"},
+				"error in checking the path of CACert. Error message: %v", err)}
+		}
+	}
+
+	return nil
+}
+
+func (configInstance MonitorConfig) validateClientCert() ConfigErrors {
+	if configInstance.ClientCert == "" && configInstance.ClientKey != "" {
+		return ConfigErrors{newConfigError([]string{"ClientKey"},
+			"ClientCert and ClientKey must be set together")}
+	}
+	if configInstance.ClientCert != "" && configInstance.ClientKey == "" {
+		return ConfigErrors{newConfigError([]string{"ClientCert"},
+			"ClientCert and ClientKey must be set together")}
+	}
+	if configInstance.ClientCert != "" && fileChecks {
+		_, err := tls.LoadX509KeyPair(configInstance.ClientCert, configInstance.ClientKey)
+		if err != nil {
+			return ConfigErrors{newConfigError([]string{"ClientCert"},
+				"error in loading the ClientCert and ClientKey pair. Error message: %v", err)}
+		}
+	}
+
+	return nil
+}
+
+func (configInstance MonitorConfig) validateWaitInterval() ConfigErrors {
+	if configInstance.WaitInterval < 0 || configInstance.WaitInterval > maxWaitInterval {
+		return ConfigErrors{newConfigError([]string{"WaitInterval"},
+			"the WaitInterval %v must be between 0 and %v seconds",
+			configInstance.WaitInterval, maxWaitInterval)}
+	}
+
+	return nil
+}
+
+func (configInstance MonitorConfig) validateTimeout() ConfigErrors {
+	// A negative Timeout selects the default timeout
+	if configInstance.Timeout > maxTimeout {
+		return ConfigErrors{newConfigError([]string{"Timeout"},
+			"the Timeout %v cannot be greater than %v seconds",
+			configInstance.Timeout, maxTimeout)}
+	}
+
+	return nil
+}
+
+// Check that the settings named by their YAML keys are not negative
+func validateNotNegative(values map[string]int) ConfigErrors {
+	var configErrors ConfigErrors
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if values[key] < 0 {
+			configErrors = append(configErrors, newConfigError([]string{key},
+				"the %v %v cannot be negative", key, values[key]))
 		}
 	}
 
+	return configErrors
+}
+
+func (configInstance MonitorConfig) validateRaftConfig() ConfigErrors {
+	return validateNotNegative(map[string]int{
+		"RaftPeerGracePeriod": configInstance.RaftPeerGracePeriod,
+	})
+}
+
+func (configInstance MonitorConfig) validateRunConfig() ConfigErrors {
+	return validateNotNegative(map[string]int{
+		"MaxConcurrency":    configInstance.MaxConcurrency,
+		"LivenessIntervals": configInstance.LivenessIntervals,
+		"LeaseDuration":     configInstance.LeaseDuration,
+	})
+}
+
+func (configInstance MonitorConfig) validateSnapshotConfig() ConfigErrors {
+	configErrors := validateNotNegative(map[string]int{
+		"SnapshotInterval":       configInstance.SnapshotInterval,
+		"SnapshotRetentionCount": configInstance.SnapshotRetentionCount,
+		"SnapshotRetentionAge":   configInstance.SnapshotRetentionAge,
+	})
+	if configInstance.SnapshotInterval > 0 && configInstance.SnapshotDirectory == "" {
+		configErrors = append(configErrors, newConfigError([]string{"SnapshotInterval"},
+			"the SnapshotInterval requires SnapshotDirectory to be set"))
+	}
+
+	return configErrors
+}
+
+func (configInstance MonitorConfig) validateConfigBackups() ConfigErrors {
+	return validateNotNegative(map[string]int{
+		"ConfigBackups": configInstance.ConfigBackups,
+	})
+}
+
+func (configInstance MonitorConfig) validateEncryptionConfig() ConfigErrors {
+	if configInstance.EncryptionKeyFile != "" && configInstance.EncryptionPassphraseEnv != "" {
+		return ConfigErrors{newConfigError([]string{"EncryptionPassphraseEnv"},
+			"only one of EncryptionKeyFile and EncryptionPassphraseEnv can be set")}
+	}
+
 	return nil
 }
+
+func (configInstance MonitorConfig) validateTokenSource() ConfigErrors {
+	var configErrors ConfigErrors
+	switch configInstance.TokenSource {
+	case "", TokenSourceTokens, TokenSourceEnv:
+	case TokenSourceFile:
+		if configInstance.TokenFile == "" {
+			configErrors = append(configErrors, newConfigError([]string{"TokenSource"},
+				"the TokenSource %v requires TokenFile to be set", configInstance.TokenSource))
+		}
+	case TokenSourceKubernetes:
+		if configInstance.K8sAuthRole == "" {
+			configErrors = append(configErrors, newConfigError([]string{"TokenSource"},
+				"the TokenSource %v requires K8sAuthRole to be set", configInstance.TokenSource))
+		}
+	default:
+		configErrors = append(configErrors, newConfigError([]string{"TokenSource"},
+			"the TokenSource %v is invalid. Available sources: %v",
+			configInstance.TokenSource, strings.Join(tokenSources, ", ")))
+	}
+	if configInstance.TokenName != "" &&
+		configInstance.TokenSource != "" && configInstance.TokenSource != TokenSourceTokens {
+		configErrors = append(configErrors, newConfigError([]string{"TokenName"},
+			"TokenName can only be used with the TokenSource %v", TokenSourceTokens))
+	}
+
+	return configErrors
+}
+
+func (configInstance MonitorConfig) validateK8sAuth() ConfigErrors {
+	var configErrors ConfigErrors
+	if configInstance.K8sAuthRole != "" {
+		return nil
+	}
+	values := map[string]string{
+		"K8sAuthMount":     configInstance.K8sAuthMount,
+		"K8sAuthTokenPath": configInstance.K8sAuthTokenPath,
+	}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if values[key] != "" {
+			configErrors = append(configErrors, newConfigError([]string{key},
+				"%v requires K8sAuthRole to be set", key))
+		}
+	}
+
+	return configErrors
+}
+
+func (configInstance MonitorConfig) validatePodDiscovery() ConfigErrors {
+	var configErrors ConfigErrors
+	methods := 0
+	for _, method := range []struct {
+		key   string
+		value string
+	}{
+		{"PodLabelSelector", configInstance.PodLabelSelector},
+		{"StatefulSetName", configInstance.StatefulSetName},
+		{"HeadlessServiceName", configInstance.HeadlessServiceName},
+	} {
+		if method.value == "" {
+			continue
+		}
+		methods++
+		if methods > 1 {
+			configErrors = append(configErrors, newConfigError([]string{method.key},
+				"only one of PodLabelSelector, StatefulSetName and HeadlessServiceName can be set"))
+		}
+	}
+	if configInstance.PodLabelSelector != "" {
+		_, err := labels.Parse(configInstance.PodLabelSelector)
+		if err != nil {
+			configErrors = append(configErrors, newConfigError([]string{"PodLabelSelector"},
+				"the PodLabelSelector %v is invalid: %v", configInstance.PodLabelSelector, err))
+		}
+	}
+
+	return configErrors
+}